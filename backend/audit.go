@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// auditPath is the route of the audit log of every material
+const auditPath = "/api/v1/intools/electra/audit"
+
+// Actions recorded in the audit log, the importer also records AuditImport once per run
+const (
+	AuditCreate               = "create"
+	AuditUpdate               = "update"
+	AuditDelete               = "delete"
+	AuditRotorBarCheck        = "rotor_bar_check"
+	AuditStockMovement        = "stock_movement"
+	AuditImport               = "import"
+	AuditStartingCurrentEvent = "starting_current_event"
+)
+
+// auditColumns is the column list every audit query selects, in scanAuditEntry order
+const auditColumns = "id, occurred_at, actor, endpoint, action, material_id, changes"
+
+// FieldChange is the value of one column before and after a change, named after the column
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditEntry is one append-only record of a change, MaterialID is nil for importer runs
+type AuditEntry struct {
+	ID         int64         `json:"id"`
+	OccurredAt time.Time     `json:"occurred_at"`
+	Actor      string        `json:"actor"`
+	Endpoint   string        `json:"endpoint"`
+	Action     string        `json:"action"`
+	MaterialID *int          `json:"material_id"`
+	Changes    []FieldChange `json:"changes"`
+}
+
+type AuditResponse struct {
+	Request struct {
+		Limit  int    `json:"limit"`
+		Offset int    `json:"offset"`
+		Since  string `json:"since,omitempty"`
+		Actor  string `json:"actor,omitempty"`
+	} `json:"request"`
+	Response struct {
+		Count   int          `json:"count"`
+		Total   int          `json:"total"`
+		Next    *string      `json:"next"`
+		Prev    *string      `json:"prev"`
+		Success bool         `json:"success"`
+		Data    []AuditEntry `json:"data"`
+	} `json:"response"`
+}
+
+// auditFilter narrows the audit log, zero values match everything
+type auditFilter struct {
+	MaterialID int
+	Since      time.Time
+	Actor      string
+	// Plants keeps the entries of materials currently on those lowercase plants, nil keeps every entry
+	Plants []string
+}
+
+// Function to list the audit log, filtered by ?since= and ?actor=.
+// Entries hold PIC contacts, so a caller only sees the materials of plants it holds a role on,
+// importer runs and deleted materials only show to roles on every plant.
+func getAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := newQueryParser(r)
+	limit, offset := query.pagination()
+	filter := auditFilter{
+		Since:  query.date("since", "must be an RFC 3339 timestamp or a YYYY-MM-DD date", time.RFC3339, "2006-01-02"),
+		Actor:  strings.TrimSpace(r.URL.Query().Get("actor")),
+		Plants: currentUser(r).plants(),
+	}
+	if !query.valid(w) {
+		return
+	}
+
+	writeAuditPage(w, r, filter, limit, offset)
+}
+
+// Function to list the audit log of one material, latest first, including after it was deleted
+func getMaterialHistory(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := newQueryParser(r)
+	limit, offset := query.pagination()
+	if !query.valid(w) {
+		return
+	}
+
+	writeAuditPage(w, r, auditFilter{MaterialID: id, Plants: currentUser(r).plants()}, limit, offset)
+}
+
+func writeAuditPage(w http.ResponseWriter, r *http.Request, filter auditFilter, limit, offset int) {
+	page, err := recordRepo.AuditLog(r.Context(), filter, limit, offset)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting audit entries: %v", err), http.StatusInternalServerError)
+		return
+	}
+	entries, total := page.Entries, page.Total
+
+	var response AuditResponse
+	response.Request.Limit = limit
+	response.Request.Offset = offset
+	response.Request.Actor = filter.Actor
+	if !filter.Since.IsZero() {
+		response.Request.Since = filter.Since.Format(time.RFC3339)
+	}
+	response.Response.Count = len(entries)
+	response.Response.Total = total
+	response.Response.Next, response.Response.Prev = pageCursors(r, limit, offset, total)
+	response.Response.Success = true
+	response.Response.Data = entries
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func buildAuditWhereClause(filter auditFilter) (string, []interface{}) {
+	where := " WHERE true"
+	var args []interface{}
+	if filter.MaterialID != 0 {
+		args = append(args, filter.MaterialID)
+		where += " AND material_id = $" + strconv.Itoa(len(args))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since)
+		where += " AND occurred_at >= $" + strconv.Itoa(len(args))
+	}
+	if filter.Actor != "" {
+		args = append(args, filter.Actor)
+		where += " AND actor = $" + strconv.Itoa(len(args))
+	}
+	if filter.Plants != nil {
+		args = append(args, filter.Plants)
+		where += " AND material_id IN (SELECT id FROM public.list_materials WHERE lower(plant) = ANY($" + strconv.Itoa(len(args)) + "))"
+	}
+	return where, args
+}
+
+// Function to count the audit entries matching filter
+func countAuditEntries(ctx context.Context, db querier, filter auditFilter) (int, error) {
+	where, args := buildAuditWhereClause(filter)
+
+	var total int
+	if err := db.QueryRow(ctx, "SELECT count(*) FROM public.audit_log"+where, args...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("unable to execute count query: %w", err)
+	}
+	return total, nil
+}
+
+// Function to select a page of the audit log, latest first
+func selectAuditEntries(ctx context.Context, db querier, filter auditFilter, limit, offset int) ([]AuditEntry, error) {
+	where, args := buildAuditWhereClause(filter)
+	query := "SELECT " + auditColumns + " FROM public.audit_log" + where + " ORDER BY id DESC"
+	if limit > 0 {
+		args = append(args, limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	args = append(args, offset)
+	query += " OFFSET $" + strconv.Itoa(len(args))
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Function to scan one row selected with auditColumns
+func scanAuditEntry(row pgx.Row) (AuditEntry, error) {
+	var entry AuditEntry
+	err := row.Scan(&entry.ID, &entry.OccurredAt, &entry.Actor, &entry.Endpoint, &entry.Action, &entry.MaterialID, &entry.Changes)
+	return entry, err
+}
+
+// Function to append an entry to the audit log, occurred_at is the time of the transaction
+func insertAuditEntry(ctx context.Context, db querier, entry AuditEntry) error {
+	if entry.Changes == nil {
+		entry.Changes = []FieldChange{}
+	}
+	_, err := db.Exec(ctx,
+		`INSERT INTO public.audit_log (actor, endpoint, action, material_id, changes)
+		VALUES(@actor, @endpoint, @action, @material_id, @changes)`,
+		pgx.NamedArgs{
+			"actor":       entry.Actor,
+			"endpoint":    entry.Endpoint,
+			"action":      entry.Action,
+			"material_id": entry.MaterialID,
+			"changes":     entry.Changes,
+		})
+	if err != nil {
+		return fmt.Errorf("unable to insert audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// Function to run a material mutation and append its audit entry, by change, in the same transaction.
+// The stored material is selected FOR UPDATE first, so a concurrent change cannot slip between the diffed versions.
+// mutate gets that material, or nil for a create, and returns the stored material or nil after a delete.
+func auditChange(ctx context.Context, db *pgxpool.Pool, change MaterialChange, action string, id int, mutate func(tx pgx.Tx, before *Material) (*Material, error)) (*Material, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var before *Material
+	if action != AuditCreate {
+		stored, err := selectMaterialForUpdate(ctx, tx, id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMaterialNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		before = &stored
+	}
+
+	after, err := mutate(tx, before)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertAuditEntry(ctx, tx, AuditEntry{
+		Actor:      change.Actor,
+		Endpoint:   change.Endpoint,
+		Action:     action,
+		MaterialID: &id,
+		Changes:    diffMaterials(before, after),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("unable to commit: %w", err)
+	}
+
+	return after, nil
+}
+
+// Function to list the columns that differ between two versions of a material.
+// A nil material stands for a missing row, so only the non-empty columns of the other one are listed.
+func diffMaterials(before, after *Material) []FieldChange {
+	old, current := auditValues(before), auditValues(after)
+
+	fields := map[string]bool{}
+	for field := range old {
+		fields[field] = true
+	}
+	for field := range current {
+		fields[field] = true
+	}
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	changes := []FieldChange{}
+	for _, field := range names {
+		if reflect.DeepEqual(old[field], current[field]) {
+			continue
+		}
+		change := FieldChange{Field: field, Before: old[field], After: current[field]}
+		if before == nil {
+			change.Before = nil
+		}
+		if after == nil {
+			change.After = nil
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// Function to map the stored columns of a material to their JSON values, specs keys become specs.<key>.
+// A nil material maps to the values of an empty one.
+func auditValues(material *Material) map[string]interface{} {
+	if material == nil {
+		material = &Material{}
+	}
+
+	args := materialArgs(*material)
+	delete(args, "id")
+	for key, value := range material.Specs {
+		args["specs."+key] = value
+	}
+	delete(args, "specs")
+
+	values := map[string]interface{}{}
+	for column, value := range args {
+		// A JSON round trip compares times, pointers and numbers of any width alike
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			continue
+		}
+		values[column] = decoded
+	}
+	return values
+}
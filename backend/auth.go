@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// authPath is the base route of login and user management
+const authPath = "/api/v1/intools/electra/auth"
+
+// Roles in increasing order of rights, each one is granted per plant
+const (
+	RoleViewer           = "viewer"
+	RolePlanner          = "planner"
+	RoleMaintenanceAdmin = "maintenance-admin"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RolePlanner: 2, RoleMaintenanceAdmin: 3}
+
+// allPlants grants a role on every plant
+const allPlants = "*"
+
+// Password hashing parameters, stored with every hash so they can be raised later
+const (
+	passwordIterations = 210000
+	passwordSaltLength = 16
+	passwordKeyLength  = 32
+	passwordScheme     = "pbkdf2-sha256"
+)
+
+// authSecret signs the session tokens and authTokenTTL is how long they stay valid, both set from the config
+var (
+	authSecret   []byte
+	authTokenTTL time.Duration
+)
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+// PlantRole is a role held on one plant, or on every plant when Plant is allPlants
+type PlantRole struct {
+	Plant string `json:"plant"`
+	Role  string `json:"role"`
+}
+
+type User struct {
+	ID          int64       `json:"id"`
+	Username    string      `json:"username"`
+	DisplayName string      `json:"display_name"`
+	Disabled    bool        `json:"disabled"`
+	Roles       []PlantRole `json:"roles"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type LoginResponse struct {
+	Response struct {
+		Success   bool      `json:"success"`
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+		User      User      `json:"user"`
+	} `json:"response"`
+}
+
+type UserResponse struct {
+	Response struct {
+		Success bool `json:"success"`
+		Data    User `json:"data"`
+	} `json:"response"`
+}
+
+// tokenClaims is the signed payload of a session token
+type tokenClaims struct {
+	UserID    int64 `json:"sub"`
+	IssuedAt  int64 `json:"iat"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+type userContextKey struct{}
+
+// Function to report whether the user holds at least role on plant
+func (user User) can(plant, role string) bool {
+	for _, held := range user.Roles {
+		if (held.Plant == allPlants || strings.EqualFold(held.Plant, plant)) && roleRanks[held.Role] >= roleRanks[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to list the lowercase plants the user holds any role on, nil when a role covers every plant
+func (user User) plants() []string {
+	plants := []string{}
+	for _, held := range user.Roles {
+		if held.Plant == allPlants {
+			return nil
+		}
+		plants = append(plants, strings.ToLower(held.Plant))
+	}
+	return plants
+}
+
+func login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request LoginRequest
+	if err := decodeJSON(r, &request); err != nil {
+		writeInputError(w, err)
+		return
+	}
+
+	user, hash, err := userRepo.GetByUsername(r.Context(), request.Username)
+	if err != nil && !errors.Is(err, errUserNotFound) {
+		http.Error(w, fmt.Sprintf("Error selecting user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// A missing user still pays for a hash so response times do not reveal usernames
+	if errors.Is(err, errUserNotFound) {
+		hash = dummyPasswordHash
+	}
+	if !verifyPassword(request.Password, hash) || err != nil || user.Disabled {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	token, expiresAt, err := signToken(user.ID, time.Now())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error signing token: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response LoginResponse
+	response.Response.Success = true
+	response.Response.Token = token
+	response.Response.ExpiresAt = expiresAt
+	response.Response.User = user
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func getCurrentUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var response UserResponse
+	response.Response.Success = true
+	response.Response.Data = currentUser(r)
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Function to wrap a handler so it only runs for a valid bearer token of an enabled user
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		userID, err := verifyToken(token, time.Now())
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="intools"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := userRepo.Get(r.Context(), userID)
+		if errors.Is(err, errUserNotFound) || err == nil && user.Disabled {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="intools"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error selecting user: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if stats := requestStatsFrom(r.Context()); stats != nil {
+			stats.User = user.Username
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	}
+}
+
+// Function to return the user requireAuth attached to the request
+func currentUser(r *http.Request) User {
+	user, _ := r.Context().Value(userContextKey{}).(User)
+	return user
+}
+
+// Function to check the current user holds at least role on every plant given, writing a 403 when not
+func authorize(w http.ResponseWriter, r *http.Request, role string, plants ...string) bool {
+	user := currentUser(r)
+	for _, plant := range plants {
+		if !user.can(plant, role) {
+			http.Error(w, fmt.Sprintf("Forbidden: %s on plant %s is required", role, plant), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// Function to sign a token for userID, valid for authTokenTTL from now
+func signToken(userID int64, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(authTokenTTL)
+	payload, err := json.Marshal(tokenClaims{UserID: userID, IssuedAt: now.Unix(), ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + tokenSignature(encoded), expiresAt, nil
+}
+
+// Function to check the signature and expiry of a token and return its user ID
+func verifyToken(token string, now time.Time) (int64, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(tokenSignature(encoded))) {
+		return 0, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, errInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || now.Unix() >= claims.ExpiresAt {
+		return 0, errInvalidToken
+	}
+
+	return claims.UserID, nil
+}
+
+func tokenSignature(encoded string) string {
+	mac := hmac.New(sha256.New, authSecret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// dummyPasswordHash is verified against when the username does not exist
+var dummyPasswordHash = hashPasswordWithSalt("", make([]byte, passwordSaltLength), passwordIterations)
+
+// Function to hash a password with a random salt
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("unable to generate salt: %w", err)
+	}
+	return hashPasswordWithSalt(password, salt, passwordIterations), nil
+}
+
+// Function to encode a hash as pbkdf2-sha256$iterations$salt$key
+func hashPasswordWithSalt(password string, salt []byte, iterations int) string {
+	key := pbkdf2.Key([]byte(password), salt, iterations, passwordKeyLength, sha256.New)
+	return strings.Join([]string{
+		passwordScheme,
+		strconv.Itoa(iterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$")
+}
+
+// Function to compare a password to a hash made by hashPassword in constant time
+func verifyPassword(password, hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+
+	expected := hashPasswordWithSalt(password, salt, iterations)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Attribute types of a category
+const (
+	AttributeNumber = "number"
+	AttributeString = "string"
+)
+
+// Attribute is one specification a category declares
+type Attribute struct {
+	Name       string `json:"name"`
+	Unit       string `json:"unit"`
+	Type       string `json:"type"`
+	Filterable bool   `json:"filterable"`
+	// Column is the SQL expression of the attribute, a key of specs unless it has its own column
+	Column string `json:"-"`
+}
+
+// Category is a kind of material and the attributes its specs hold
+type Category struct {
+	Slug       string      `json:"slug"`
+	Name       string      `json:"name"`
+	Attributes []Attribute `json:"attributes"`
+}
+
+type CategoriesResponse struct {
+	Response struct {
+		Count   int        `json:"count"`
+		Success bool       `json:"success"`
+		Data    []Category `json:"data"`
+	} `json:"response"`
+}
+
+// hvMotorCategory is stored in the category column, its attributes predate specs and have their own columns
+var hvMotorCategory = Category{Slug: "hv-motor", Name: "HV Motor", Attributes: []Attribute{
+	{Name: "capacity", Unit: "kW", Type: AttributeNumber, Filterable: true, Column: "capacity"},
+	{Name: "voltage", Unit: "V", Type: AttributeNumber, Filterable: true, Column: "voltage"},
+	{Name: "current", Unit: "A", Type: AttributeNumber, Filterable: true, Column: "current"},
+	{Name: "rpm", Unit: "rpm", Type: AttributeNumber, Filterable: true, Column: "rpm"},
+	{Name: "frame", Type: AttributeNumber, Filterable: true, Column: "frame"},
+	{Name: "shaft_diameter", Unit: "mm", Type: AttributeNumber, Filterable: true, Column: "shaft_diameter"},
+	{Name: "base_width", Unit: "mm", Type: AttributeNumber, Filterable: true, Column: "base_width"},
+	{Name: "base_length", Unit: "mm", Type: AttributeNumber, Filterable: true, Column: "base_length"},
+	{Name: "c", Unit: "mm", Type: AttributeNumber, Filterable: true, Column: "c"},
+	{Name: "e", Unit: "mm", Type: AttributeNumber, Filterable: true, Column: "e"},
+	{Name: "h", Unit: "mm", Type: AttributeNumber, Filterable: true, Column: "h"},
+}}
+
+// categories is the registry of every category, new categories only need an entry here
+var categories = []Category{
+	hvMotorCategory,
+	{Slug: "lv-motor", Name: "LV Motor", Attributes: []Attribute{
+		specAttribute("capacity", "kW", AttributeNumber, true),
+		specAttribute("voltage", "V", AttributeNumber, true),
+		specAttribute("current", "A", AttributeNumber, true),
+		specAttribute("rpm", "rpm", AttributeNumber, true),
+		specAttribute("frame", "", AttributeNumber, true),
+		specAttribute("mounting", "", AttributeString, true),
+	}},
+	{Slug: "transformer", Name: "Transformer", Attributes: []Attribute{
+		specAttribute("rating", "kVA", AttributeNumber, true),
+		specAttribute("primary_voltage", "V", AttributeNumber, true),
+		specAttribute("secondary_voltage", "V", AttributeNumber, true),
+		specAttribute("impedance", "%", AttributeNumber, true),
+		specAttribute("vector_group", "", AttributeString, true),
+		specAttribute("cooling", "", AttributeString, true),
+		specAttribute("oil_volume", "L", AttributeNumber, false),
+	}},
+	{Slug: "breaker", Name: "Breaker", Attributes: []Attribute{
+		specAttribute("rated_voltage", "V", AttributeNumber, true),
+		specAttribute("rated_current", "A", AttributeNumber, true),
+		specAttribute("breaking_capacity", "kA", AttributeNumber, true),
+		specAttribute("breaker_type", "", AttributeString, true),
+		specAttribute("poles", "", AttributeNumber, true),
+	}},
+	{Slug: "vfd", Name: "VFD", Attributes: []Attribute{
+		specAttribute("capacity", "kW", AttributeNumber, true),
+		specAttribute("input_voltage", "V", AttributeNumber, true),
+		specAttribute("output_current", "A", AttributeNumber, true),
+		specAttribute("enclosure", "", AttributeString, true),
+		specAttribute("firmware", "", AttributeString, false),
+	}},
+}
+
+// Function to declare an attribute stored under its name in the specs column
+func specAttribute(name, unit, attributeType string, filterable bool) Attribute {
+	column := "specs->>'" + name + "'"
+	if attributeType == AttributeNumber {
+		column = "(" + column + ")::numeric"
+	}
+	return Attribute{Name: name, Unit: unit, Type: attributeType, Filterable: filterable, Column: column}
+}
+
+// Function to report whether the attribute is stored in the specs column
+func (attribute Attribute) inSpecs() bool {
+	return attribute.Column != attribute.Name
+}
+
+// Function to find a category by its URL slug
+func categoryBySlug(slug string) (Category, bool) {
+	for _, category := range categories {
+		if category.Slug == slug {
+			return category, true
+		}
+	}
+	return Category{}, false
+}
+
+// Function to find a category by the name stored in the category column
+func categoryByName(name string) (Category, bool) {
+	for _, category := range categories {
+		if category.Name == name {
+			return category, true
+		}
+	}
+	return Category{}, false
+}
+
+// Function to dispatch /materials/categories, /materials/{category}, /materials/{category}/{id} and /materials/{id}/history
+func categoryRoutes(w http.ResponseWriter, r *http.Request) {
+	slug := strings.Trim(strings.TrimPrefix(r.URL.Path, materialsPath+"/"), "/")
+	if slug == "categories" {
+		getCategories(w, r)
+		return
+	}
+	// History is kept per material whatever its category
+	if idPart, sub, _ := strings.Cut(slug, "/"); sub == "history" {
+		if id, err := strconv.Atoi(idPart); err == nil && id > 0 {
+			getMaterialHistory(w, r, id)
+			return
+		}
+	}
+
+	slug, idPart, hasID := strings.Cut(slug, "/")
+	category, ok := categoryBySlug(slug)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !hasID {
+		getCategoryMaterials(w, r, category)
+		return
+	}
+
+	id, err := strconv.Atoi(idPart)
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		getMaterial(w, r, category, id)
+	case http.MethodPost:
+		createMaterial(w, r, category, id)
+	case http.MethodPut:
+		replaceMaterial(w, r, category, id)
+	case http.MethodPatch:
+		patchMaterial(w, r, category, id)
+	case http.MethodDelete:
+		deleteMaterial(w, r, category, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getCategories(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var response CategoriesResponse
+	response.Response.Count = len(categories)
+	response.Response.Success = true
+	response.Response.Data = categories
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func getCategoryMaterials(w http.ResponseWriter, r *http.Request, category Category) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract limit and offset parameters from query string
+	query := newQueryParser(r)
+	limit, offset := query.pagination()
+
+	// Every filterable attribute of the category can be filtered, numbers also by range and tolerance
+	params := QueryParams{Category: category.Name, Search: query.search("q"), Sort: query.sort("sort", sortFields())}
+	for _, attribute := range category.Attributes {
+		if !attribute.Filterable {
+			continue
+		}
+		if attribute.Type == AttributeNumber {
+			params.Attributes = append(params.Attributes, numericColumn{Column: attribute.Column, Name: attribute.Name, Filter: query.numericFilter(attribute.Name, attribute.Unit)})
+		} else if value := r.URL.Query().Get(attribute.Name); value != "" {
+			params.Texts = append(params.Texts, textColumn{Column: attribute.Column, Name: attribute.Name, Value: value})
+		}
+	}
+	if !query.valid(w) {
+		return
+	}
+
+	writeMaterialsPage(w, r, params, limit, offset)
+}
+
+// Function to check the specs of a material against the attributes of its category
+func validateSpecs(category Category, specs map[string]interface{}) InputErrors {
+	var problems InputErrors
+
+	declared := map[string]Attribute{}
+	for _, attribute := range category.Attributes {
+		declared[attribute.Name] = attribute
+	}
+
+	for name, value := range specs {
+		problem := InputError{Field: "specs." + name, Value: fmt.Sprint(value)}
+		attribute, ok := declared[name]
+		// Attributes with a column of their own are set through specifications and size, not specs
+		if !ok || !attribute.inSpecs() {
+			problem.Reason = "is not an attribute of " + category.Name
+			problems = append(problems, problem)
+			continue
+		}
+		switch value.(type) {
+		case float64:
+			if attribute.Type != AttributeNumber {
+				problem.Reason = "must be a string"
+			}
+		case string:
+			if attribute.Type != AttributeString {
+				problem.Reason = "must be a number"
+			}
+		default:
+			problem.Reason = "must be a " + attribute.Type
+		}
+		if problem.Reason != "" {
+			problems = append(problems, problem)
+		}
+	}
+
+	return problems
+}
+
+// Function to reject the attribute columns a category does not declare, the attributes of
+// every category but HV Motor live in specs, so a capacity of an LV motor is set as specs.capacity
+func validateColumns(category Category, material Material) InputErrors {
+	var problems InputErrors
+
+	declared := map[string]Attribute{}
+	for _, attribute := range category.Attributes {
+		declared[attribute.Name] = attribute
+	}
+
+	for _, column := range attributeColumns(material) {
+		if column.Value == 0 {
+			continue
+		}
+		attribute, ok := declared[column.Name]
+		if ok && !attribute.inSpecs() {
+			continue
+		}
+		problem := InputError{Field: column.Field, Value: strconv.FormatFloat(column.Value, 'f', -1, 64), Reason: "is not an attribute of " + category.Name}
+		if ok {
+			problem.Reason += ", set specs." + column.Name + " instead"
+		}
+		problems = append(problems, problem)
+	}
+
+	return problems
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Config holds every setting of the backend, loaded once at startup by loadConfig
+type Config struct {
+	DatabaseURL       string
+	DBMaxConns        int32
+	DBMinConns        int32
+	DBMaxConnLifetime time.Duration
+	DBConnectTimeout  time.Duration
+	ListenAddr        string
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	CORSOrigins       []string
+	LogLevel          string
+	AuthTokenSecret   string
+	AuthTokenTTL      time.Duration
+	MigrateOnStart    bool
+}
+
+// configDefaults are used for any key missing from both the environment and the config file.
+// DATABASE_URL and AUTH_TOKEN_SECRET have no default, they are secrets.
+var configDefaults = map[string]string{
+	"DB_MAX_CONNS":         "10",
+	"DB_MIN_CONNS":         "0",
+	"DB_MAX_CONN_LIFETIME": "1h",
+	"DB_CONNECT_TIMEOUT":   "1m",
+	"LISTEN_ADDR":          ":8080",
+	"READ_TIMEOUT":         "15s",
+	"WRITE_TIMEOUT":        "30s",
+	"IDLE_TIMEOUT":         "60s",
+	"SHUTDOWN_TIMEOUT":     "10s",
+	"CORS_ALLOWED_ORIGINS": "*",
+	"LOG_LEVEL":            "info",
+	"AUTH_TOKEN_TTL":       "12h",
+	"MIGRATE_ON_START":     "false",
+}
+
+// minTokenSecretLength is the shortest AUTH_TOKEN_SECRET accepted
+const minTokenSecretLength = 32
+
+var logLevels = []string{levelDebug, levelInfo, levelWarn, levelError}
+
+// Function to load the config from the environment, falling back to the KEY=VALUE file at path.
+// An empty path reads ".env" when it exists. Every problem is reported at once.
+func loadConfig(path string) (Config, error) {
+	file := map[string]string{}
+	if path != "" || fileExists(".env") {
+		if path == "" {
+			path = ".env"
+		}
+		var err error
+		if file, err = readEnvFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	lookup := func(key string) string {
+		if value, ok := os.LookupEnv(key); ok {
+			return strings.TrimSpace(value)
+		}
+		if value, ok := file[key]; ok {
+			return value
+		}
+		return configDefaults[key]
+	}
+
+	var problems []string
+	duration := func(key string) time.Duration {
+		value, err := time.ParseDuration(lookup(key))
+		if err != nil || value <= 0 {
+			problems = append(problems, key+" must be a positive duration such as 30s")
+		}
+		return value
+	}
+	boolean := func(key string) bool {
+		value, err := strconv.ParseBool(lookup(key))
+		if err != nil {
+			problems = append(problems, key+" must be true or false")
+		}
+		return value
+	}
+	count := func(key string) int32 {
+		value, err := strconv.ParseInt(lookup(key), 10, 32)
+		if err != nil || value < 0 {
+			problems = append(problems, key+" must be a non-negative integer")
+		}
+		return int32(value)
+	}
+
+	config := Config{
+		DatabaseURL:       lookup("DATABASE_URL"),
+		DBMaxConns:        count("DB_MAX_CONNS"),
+		DBMinConns:        count("DB_MIN_CONNS"),
+		DBMaxConnLifetime: duration("DB_MAX_CONN_LIFETIME"),
+		DBConnectTimeout:  duration("DB_CONNECT_TIMEOUT"),
+		ListenAddr:        lookup("LISTEN_ADDR"),
+		ReadTimeout:       duration("READ_TIMEOUT"),
+		WriteTimeout:      duration("WRITE_TIMEOUT"),
+		IdleTimeout:       duration("IDLE_TIMEOUT"),
+		ShutdownTimeout:   duration("SHUTDOWN_TIMEOUT"),
+		LogLevel:          strings.ToLower(lookup("LOG_LEVEL")),
+		AuthTokenSecret:   lookup("AUTH_TOKEN_SECRET"),
+		AuthTokenTTL:      duration("AUTH_TOKEN_TTL"),
+		MigrateOnStart:    boolean("MIGRATE_ON_START"),
+	}
+	for _, origin := range strings.Split(lookup("CORS_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			config.CORSOrigins = append(config.CORSOrigins, origin)
+		}
+	}
+
+	if config.DatabaseURL == "" {
+		problems = append(problems, "DATABASE_URL is required")
+	} else if _, err := pgxpool.ParseConfig(config.DatabaseURL); err != nil {
+		problems = append(problems, "DATABASE_URL is invalid: "+err.Error())
+	}
+	if config.DBMaxConns < 1 {
+		problems = append(problems, "DB_MAX_CONNS must be at least 1")
+	}
+	if config.DBMinConns > config.DBMaxConns {
+		problems = append(problems, "DB_MIN_CONNS must not exceed DB_MAX_CONNS")
+	}
+	if _, _, err := net.SplitHostPort(config.ListenAddr); err != nil {
+		problems = append(problems, "LISTEN_ADDR must be host:port or :port")
+	}
+	if len(config.CORSOrigins) == 0 {
+		problems = append(problems, "CORS_ALLOWED_ORIGINS must list at least one origin")
+	}
+	if !containsString(logLevels, config.LogLevel) {
+		problems = append(problems, "LOG_LEVEL must be one of "+strings.Join(logLevels, ", "))
+	}
+	if len(config.AuthTokenSecret) < minTokenSecretLength {
+		problems = append(problems, fmt.Sprintf("AUTH_TOKEN_SECRET must be at least %d characters", minTokenSecretLength))
+	}
+
+	if len(problems) > 0 {
+		return Config{}, errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+
+	return config, nil
+}
+
+// Function to build the pool config from DATABASE_URL and the pool settings
+func (config Config) poolConfig() (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(config.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.MaxConns = config.DBMaxConns
+	poolConfig.MinConns = config.DBMinConns
+	poolConfig.MaxConnLifetime = config.DBMaxConnLifetime
+	poolConfig.ConnConfig.Tracer = queryTracer{}
+
+	return poolConfig, nil
+}
+
+// Function to read a file of KEY=VALUE lines, blank lines and # comments are skipped
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open config file: %w", err)
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("config file %s line %d: expected KEY=VALUE", path, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read config file: %w", err)
+	}
+
+	return values, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats of a material list, JSON stays the default
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+var exportContentTypes = map[string]string{
+	FormatCSV:  "text/csv; charset=utf-8",
+	FormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// exportHeaders are the headers of data.csv, so an export can be edited in Excel and imported again
+var exportHeaders = []string{
+	"No.", "Plant", "Electrical Room", "Motor Name", "Capacity [kW]", "Voltage [V]", "Current [A]", "RPM",
+	"Maker", "Serial Number", "When ?", "Check ?", "Check Date", "Check Status", "REASON", "Remark",
+	"frame", "Type", "Installed\n  Qty", "Standby", "Spare",
+	"Shaft \n Dia", "Base \n Width", "Base \n Length", "C", "E", "H", "Operation", "Remark",
+}
+
+// exportCell is one value of an exported row, numbers are typed as numbers in XLSX
+type exportCell struct {
+	Text   string
+	Number bool
+}
+
+// materialExporter writes materials one at a time in a file format
+type materialExporter interface {
+	WriteRow(cells []exportCell) error
+	Close() error
+}
+
+// Function to choose the format of a material list from ?format= or else the Accept header.
+// It returns false when ?format= names an unknown format.
+func exportFormat(r *http.Request) (string, bool) {
+	switch format := strings.ToLower(r.URL.Query().Get("format")); format {
+	case FormatJSON, FormatCSV, FormatXLSX:
+		return format, true
+	case "":
+	default:
+		return "", false
+	}
+
+	accept := r.Header.Get("Accept")
+	for _, format := range []string{FormatCSV, FormatXLSX} {
+		if strings.Contains(accept, strings.Split(exportContentTypes[format], ";")[0]) {
+			return format, true
+		}
+	}
+	return FormatJSON, true
+}
+
+// Function to stream the materials matching params as CSV or XLSX, straight from the repository rows
+func exportMaterials(w http.ResponseWriter, r *http.Request, format string, params QueryParams, limit, offset int) {
+	rows, err := materialRepo.Stream(r.Context(), params, limit, offset)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting materials: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	filename := fmt.Sprintf("materials-%s.%s", time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	var exporter materialExporter
+	if format == FormatXLSX {
+		exporter, err = newXLSXExporter(w)
+	} else {
+		exporter, err = newCSVExporter(w)
+	}
+
+	// The status is already sent, so a failure can only be logged and the file left truncated
+	if err == nil {
+		err = exporter.WriteRow(headerCells())
+	}
+	for n := 1; err == nil && rows.Next(); n++ {
+		var material Material
+		if material, err = rows.Material(); err == nil {
+			err = exporter.WriteRow(materialCells(n, material))
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err == nil {
+		err = exporter.Close()
+	}
+	if err != nil {
+		logf(levelError, "Error exporting materials as %s: %v", format, err)
+	}
+}
+
+func headerCells() []exportCell {
+	cells := make([]exportCell, len(exportHeaders))
+	for i, header := range exportHeaders {
+		cells[i] = exportCell{Text: header}
+	}
+	return cells
+}
+
+// Function to lay a material out in the columns of exportHeaders, n is the row number
+func materialCells(n int, material Material) []exportCell {
+	text := func(value string) exportCell { return exportCell{Text: value} }
+	number := func(value int) exportCell { return exportCell{Text: strconv.Itoa(value), Number: true} }
+	decimal := func(value float64) exportCell {
+		return exportCell{Text: strconv.FormatFloat(value, 'f', -1, 64), Number: true}
+	}
+
+	checkDate := ""
+	if material.RotorBar.CheckDate != nil {
+		// data.csv holds dates as Excel writes them, month first
+		checkDate = material.RotorBar.CheckDate.Format("1/2/2006")
+	}
+
+	return []exportCell{
+		number(n), text(material.Plant), text(material.Area), text(material.Name),
+		decimal(material.Specifications.Capacity), decimal(material.Specifications.Voltage),
+		decimal(material.Specifications.Current), decimal(material.Specifications.RPM),
+		text(material.Maker), text(material.SerialNumber),
+		text(material.StartingCurrent.When), text(material.StartingCurrent.Check),
+		text(checkDate), text(material.RotorBar.CheckStatus), text(material.RotorBar.Reason), text(material.RotorBar.Remark),
+		number(material.Frame), text(material.Type),
+		number(int(material.Installed)), number(int(material.StandBy)), number(int(material.Spare)),
+		decimal(material.Size.ShaftDiameter), decimal(material.Size.BaseWidth), decimal(material.Size.BaseLength),
+		decimal(material.Size.C), decimal(material.Size.E), decimal(material.Size.H),
+		text(material.Operation), text(material.Remark),
+	}
+}
+
+type csvExporter struct {
+	writer *csv.Writer
+}
+
+func newCSVExporter(w io.Writer) (*csvExporter, error) {
+	// The byte order mark makes Excel read the file as UTF-8
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return nil, err
+	}
+	return &csvExporter{writer: csv.NewWriter(w)}, nil
+}
+
+func (e *csvExporter) WriteRow(cells []exportCell) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		record[i] = cell.Text
+	}
+	return e.writer.Write(record)
+}
+
+func (e *csvExporter) Close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// xlsxExporter writes a single sheet workbook. The fixed parts are written first,
+// then the sheet is streamed row by row into the last zip entry.
+type xlsxExporter struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+	row     int
+}
+
+// xlsxParts are the fixed parts of the workbook, in the order they are written
+var xlsxParts = []struct {
+	Name    string
+	Content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Materials" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	// Style 1 is the bold, wrapped header row
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1" applyAlignment="1"><alignment wrapText="1"/></xf></cellXfs>` +
+		`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+		`</styleSheet>`},
+}
+
+func newXLSXExporter(w io.Writer) (*xlsxExporter, error) {
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		entry, err := archive.Create(part.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(entry, part.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	entry, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheet := bufio.NewWriter(entry)
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	return &xlsxExporter{archive: archive, sheet: sheet}, nil
+}
+
+func (e *xlsxExporter) WriteRow(cells []exportCell) error {
+	e.row++
+	// The first row is the header
+	style := ""
+	if e.row == 1 {
+		style = ` s="1"`
+	}
+
+	fmt.Fprintf(e.sheet, `<row r="%d">`, e.row)
+	for i, cell := range cells {
+		ref := columnName(i) + strconv.Itoa(e.row)
+		if cell.Number {
+			fmt.Fprintf(e.sheet, `<c r="%s"><v>%s</v></c>`, ref, cell.Text)
+			continue
+		}
+		fmt.Fprintf(e.sheet, `<c r="%s" t="inlineStr"%s><is><t xml:space="preserve">`, ref, style)
+		if err := xml.EscapeText(e.sheet, []byte(cell.Text)); err != nil {
+			return err
+		}
+		e.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := e.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (e *xlsxExporter) Close() error {
+	e.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.archive.Close()
+}
+
+// Function to name a zero-based column as Excel does: A to Z, then AA, AB and so on
+func columnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
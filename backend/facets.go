@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// facetColumns are the columns ?facets= can count the values of, true for numeric columns
+var facetColumns = map[string]bool{
+	"plant": false, "area": false, "maker": false, "type": false, "operation": false,
+	"capacity": true, "voltage": true, "current": true, "rpm": true, "frame": true,
+	"shaft_diameter": true, "base_width": true, "base_length": true, "c": true, "e": true, "h": true,
+}
+
+// FacetValue is one distinct value of a facet and the number of matching materials holding it, Value is nil for no value
+type FacetValue struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+// Function to read a comma separated list of facets, nil when missing
+func (p *queryParser) facets(name string) []string {
+	value := strings.TrimSpace(p.query.Get(name))
+	if value == "" {
+		return nil
+	}
+
+	var facets []string
+	seen := map[string]bool{}
+	for _, facet := range strings.Split(value, ",") {
+		facet = strings.TrimSpace(facet)
+		if _, ok := facetColumns[facet]; !ok {
+			p.fail(name, facet, "must be one of "+strings.Join(facetNames(), ", "))
+			continue
+		}
+		if !seen[facet] {
+			seen[facet] = true
+			facets = append(facets, facet)
+		}
+	}
+	return facets
+}
+
+// Function to list the facets in alphabetical order
+func facetNames() []string {
+	names := make([]string, 0, len(facetColumns))
+	for name := range facetColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Function to build one query counting the values of every facet among the materials matching params
+func buildFacetQuery(params QueryParams) (string, []interface{}) {
+	where, values := buildWhereClause(params)
+
+	branches := make([]string, len(params.Facets))
+	for i, facet := range params.Facets {
+		// facet is a key of facetColumns, never user input
+		branches[i] = "SELECT '" + facet + "' AS facet, " + facet + "::text AS value, COUNT(*) AS count FROM matches GROUP BY " + facet
+	}
+
+	query := "WITH matches AS (SELECT " + strings.Join(params.Facets, ", ") + " FROM public.list_materials" + where + ") " +
+		strings.Join(branches, " UNION ALL ") + " ORDER BY facet, count DESC, value"
+	return query, values
+}
+
+// Function to count the values of every facet of params among the materials matching it, most frequent value first
+func selectFacets(ctx context.Context, db querier, params QueryParams) (map[string][]FacetValue, error) {
+	query, values := buildFacetQuery(params)
+	rows, err := db.Query(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute facet query: %w", err)
+	}
+	defer rows.Close()
+
+	facets := map[string][]FacetValue{}
+	for _, facet := range params.Facets {
+		facets[facet] = []FacetValue{}
+	}
+	for rows.Next() {
+		var facet string
+		var value *string
+		var count int
+		if err := rows.Scan(&facet, &value, &count); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+
+		entry := FacetValue{Count: count}
+		if value != nil {
+			entry.Value = *value
+			// The values of numeric facets are returned as JSON numbers
+			if facetColumns[facet] {
+				if number, err := strconv.ParseFloat(*value, 64); err == nil {
+					entry.Value = number
+				}
+			}
+		}
+		facets[facet] = append(facets[facet], entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	return facets, nil
+}
@@ -1,17 +1,17 @@
-module main
+module electra
 
 go 1.18
 
 require (
 	github.com/jackc/pgx/v5 v5.5.0
 	github.com/rs/cors v1.10.1
+	golang.org/x/crypto v0.15.0
 )
 
 require (
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
 	github.com/jackc/puddle/v2 v2.2.1 // indirect
-	golang.org/x/crypto v0.15.0 // indirect
 	golang.org/x/sync v0.5.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	_ MaterialRepository = (*pgMaterialRepository)(nil)
+	_ RecordRepository   = (*pgMaterialRepository)(nil)
+	_ UserRepository     = (*pgUserRepository)(nil)
+	_ ImportRepository   = (*pgImportRepository)(nil)
+	_ MaterialRepository = (*memoryMaterialRepository)(nil)
+	_ RecordRepository   = (*memoryMaterialRepository)(nil)
+	_ UserRepository     = (*memoryUserRepository)(nil)
+	_ ImportRepository   = (*memoryImportRepository)(nil)
+)
+
+// testAdmin may change every material, testViewer only read them
+var (
+	testAdmin  = User{ID: 1, Username: "admin", Roles: []PlantRole{{Plant: allPlants, Role: RoleMaintenanceAdmin}}}
+	testViewer = User{ID: 2, Username: "viewer", Roles: []PlantRole{{Plant: allPlants, Role: RoleViewer}}}
+)
+
+// Function to serve every route of main over memory repositories holding users and materials, without signing in
+func newTestRoutes(t *testing.T, users []User, materials ...Material) (*memoryMaterialRepository, http.Handler) {
+	t.Helper()
+
+	repo := newMemoryMaterialRepository(materials...)
+	previousMaterials, previousRecords, previousUsers, previousImports := materialRepo, recordRepo, userRepo, importRepo
+	previousSecret, previousTTL := authSecret, authTokenTTL
+	materialRepo, recordRepo, userRepo, importRepo = repo, repo, newMemoryUserRepository(users...), newMemoryImportRepository(repo)
+	authSecret, authTokenTTL = []byte("test secret"), time.Hour
+	t.Cleanup(func() {
+		materialRepo, recordRepo, userRepo, importRepo = previousMaterials, previousRecords, previousUsers, previousImports
+		authSecret, authTokenTTL = previousSecret, previousTTL
+	})
+
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	return repo, mux
+}
+
+// Function to serve every route of main over memory repositories, each request signed in as user
+func newTestServer(t *testing.T, user User, materials ...Material) (*memoryMaterialRepository, http.Handler) {
+	t.Helper()
+
+	repo, routes := newTestRoutes(t, []User{user}, materials...)
+	return repo, signedIn(routes, testToken(t, user.ID))
+}
+
+// Function to send every request to routes with a bearer token
+func signedIn(routes http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+		routes.ServeHTTP(w, r)
+	})
+}
+
+// Function to sign a session token for a user ID
+func testToken(t *testing.T, userID int64) string {
+	t.Helper()
+
+	token, _, err := signToken(userID, time.Now())
+	if err != nil {
+		t.Fatalf("unable to sign a token: %v", err)
+	}
+	return token
+}
+
+// Function to send a request with an optional JSON body and return the recorded response
+func serve(t *testing.T, handler http.Handler, method, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("unable to encode body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	request := httptest.NewRequest(method, target, reader)
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// Function to decode a response body into v, failing when the status is not the expected one
+func decodeResponse(t *testing.T, recorder *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+
+	if recorder.Code != status {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, status, recorder.Body.String())
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), v); err != nil {
+		t.Fatalf("unable to decode response: %v: %s", err, recorder.Body.String())
+	}
+}
+
+// Function to build an HV motor of the test fixtures
+func testMotor(id int, plant, name string, capacity, voltage float64, spare int) Material {
+	var material Material
+	material.ID = id
+	material.Plant = plant
+	material.Area = "Substation 1"
+	material.Category = hvMotorCategory.Name
+	material.Name = name
+	material.Specifications.Capacity = capacity
+	material.Specifications.Voltage = voltage
+	material.Specifications.RPM = 1490
+	material.Installed = 1
+	material.Spare = spare
+	return material
+}
+
+func TestMaterialLifecycle(t *testing.T) {
+	repo, handler := newTestServer(t, testAdmin)
+	path := hvMotorPath + "/7"
+
+	motor := testMotor(0, "Plant A", "Boiler feed pump", 560, 6600, 1)
+	var created MaterialResponse
+	decodeResponse(t, serve(t, handler, http.MethodPost, path, motor), http.StatusCreated, &created)
+	if created.Response.Data.ID != 7 || created.Response.Data.Spare != 1 {
+		t.Fatalf("created = %+v, want id 7 with 1 spare", created.Response.Data)
+	}
+	decodeResponse(t, serve(t, handler, http.MethodPost, path, motor), http.StatusConflict, nil)
+
+	var patched MaterialResponse
+	decodeResponse(t, serve(t, handler, http.MethodPatch, path, map[string]interface{}{"name": "BFP A", "spare_qty": 9}), http.StatusOK, &patched)
+	if patched.Response.Data.Name != "BFP A" {
+		t.Errorf("name = %q, want BFP A", patched.Response.Data.Name)
+	}
+	// Quantities only change through stock movements
+	if patched.Response.Data.Spare != 1 {
+		t.Errorf("spare = %d, want the stored 1", patched.Response.Data.Spare)
+	}
+
+	decodeResponse(t, serve(t, handler, http.MethodDelete, path, nil), http.StatusNoContent, nil)
+	decodeResponse(t, serve(t, handler, http.MethodGet, path, nil), http.StatusNotFound, nil)
+
+	// The history outlives the material
+	decodeResponse(t, serve(t, handler, http.MethodGet, path+"/history", nil), http.StatusOK, nil)
+	if filter := repo.lastAuditFilter; filter.MaterialID != 7 || filter.Plants != nil {
+		t.Errorf("history filter = %+v, want material 7 on every plant", filter)
+	}
+}
+
+func TestMaterialRejectsOtherCategory(t *testing.T) {
+	_, handler := newTestServer(t, testAdmin)
+
+	motor := testMotor(0, "Plant A", "Transformer", 0, 0, 0)
+	motor.Category = "Transformer"
+	var problems struct {
+		Response struct {
+			Errors InputErrors `json:"errors"`
+		} `json:"response"`
+	}
+	decodeResponse(t, serve(t, handler, http.MethodPost, hvMotorPath+"/1", motor), http.StatusBadRequest, &problems)
+	if len(problems.Response.Errors) != 1 || problems.Response.Errors[0].Field != "category" {
+		t.Errorf("errors = %+v, want one on category", problems.Response.Errors)
+	}
+}
+
+func TestCategoryMaterialLifecycle(t *testing.T) {
+	_, handler := newTestServer(t, testAdmin, testMotor(1, "Plant A", "Pump", 560, 6600, 1))
+	path := materialsPath + "/transformer/20"
+
+	transformer := map[string]interface{}{
+		"plant": "Plant A", "area": "Substation 1", "name": "TR-01",
+		"specs": map[string]interface{}{"rating": 2500, "vector_group": "Dyn11"},
+	}
+	var created MaterialResponse
+	decodeResponse(t, serve(t, handler, http.MethodPost, path, transformer), http.StatusCreated, &created)
+	if created.Response.Data.Category != "Transformer" || created.Response.Data.Specs["rating"] != 2500.0 {
+		t.Fatalf("created = %+v, want a Transformer rated 2500", created.Response.Data)
+	}
+
+	var patched MaterialResponse
+	decodeResponse(t, serve(t, handler, http.MethodPatch, path, map[string]interface{}{"specs": map[string]interface{}{"cooling": "ONAN"}}), http.StatusOK, &patched)
+	if patched.Response.Data.Specs["cooling"] != "ONAN" || patched.Response.Data.Specs["vector_group"] != "Dyn11" {
+		t.Errorf("specs = %v, want cooling added to the stored specs", patched.Response.Data.Specs)
+	}
+
+	// A material is only reachable on the route of its category
+	decodeResponse(t, serve(t, handler, http.MethodGet, materialsPath+"/lv-motor/20", nil), http.StatusNotFound, nil)
+	decodeResponse(t, serve(t, handler, http.MethodGet, hvMotorPath+"/20", nil), http.StatusNotFound, nil)
+	decodeResponse(t, serve(t, handler, http.MethodDelete, materialsPath+"/transformer/1", nil), http.StatusNotFound, nil)
+
+	decodeResponse(t, serve(t, handler, http.MethodDelete, path, nil), http.StatusNoContent, nil)
+	decodeResponse(t, serve(t, handler, http.MethodGet, path, nil), http.StatusNotFound, nil)
+}
+
+func TestCategoryMaterialSchema(t *testing.T) {
+	_, handler := newTestServer(t, testAdmin)
+
+	tests := []struct {
+		path   string
+		body   map[string]interface{}
+		fields []string
+	}{
+		{"/transformer/1", map[string]interface{}{"specs": map[string]interface{}{"rating": "big", "capacity": 10}}, []string{"specs.capacity", "specs.rating"}},
+		// The HV Motor columns belong to HV Motor, an LV motor keeps its capacity in specs
+		{"/lv-motor/1", map[string]interface{}{"specifications": map[string]interface{}{"capacity": 55}}, []string{"specifications.capacity"}},
+		{"/breaker/1", map[string]interface{}{"size": map[string]interface{}{"c": 100}}, []string{"size.c"}},
+		{"/lv-motor/1", map[string]interface{}{"category": "VFD"}, []string{"category"}},
+	}
+	for _, test := range tests {
+		body := map[string]interface{}{"plant": "Plant A", "area": "Substation 1", "name": "X"}
+		for key, value := range test.body {
+			body[key] = value
+		}
+		var problems struct {
+			Response struct {
+				Errors InputErrors `json:"errors"`
+			} `json:"response"`
+		}
+		decodeResponse(t, serve(t, handler, http.MethodPost, materialsPath+test.path, body), http.StatusBadRequest, &problems)
+		var fields []string
+		for _, problem := range problems.Response.Errors {
+			fields = append(fields, problem.Field)
+		}
+		sort.Strings(fields)
+		if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+			t.Errorf("POST %s %v: errors on %v, want %v", test.path, test.body, fields, test.fields)
+		}
+	}
+}
+
+func TestMaterialRequiresRole(t *testing.T) {
+	_, handler := newTestServer(t, testViewer, testMotor(1, "Plant A", "Pump", 560, 6600, 1))
+
+	decodeResponse(t, serve(t, handler, http.MethodDelete, hvMotorPath+"/1", nil), http.StatusForbidden, nil)
+	decodeResponse(t, serve(t, handler, http.MethodGet, hvMotorPath+"/1", nil), http.StatusOK, nil)
+}
+
+func TestGetMaterialsByParams(t *testing.T) {
+	repo, handler := newTestServer(t, testAdmin, testMotor(1, "Plant A", "Pump", 560, 6600, 1))
+
+	// bounds formats the lower and upper bound of a numeric column, "-" when unbounded
+	bounds := func(params QueryParams, column string) string {
+		for _, numeric := range params.numericColumns() {
+			if numeric.Column != column {
+				continue
+			}
+			lower, upper := numeric.Filter.Bounds(numeric.AtLeast)
+			format := func(bound *float64) string {
+				if bound == nil {
+					return "-"
+				}
+				return strconv.FormatFloat(*bound, 'f', -1, 64)
+			}
+			return format(lower) + ".." + format(upper)
+		}
+		return ""
+	}
+
+	tests := []struct {
+		query  string
+		column string
+		bounds string
+	}{
+		{"capacity=1e3", "capacity", "1000..-"},
+		{"capacity=1MW", "capacity", "1000..-"},
+		{"voltage=6.6kV&sort=id", "voltage", "6600..6600"},
+		// An explicit zero is a filter, not a missing one
+		{"voltage=0", "voltage", "0..0"},
+		{"min_capacity=0&max_capacity=600&sort=id", "capacity", "0..600"},
+		// Capacity is a lower bound, widened by its tolerance
+		{"capacity=1200", "capacity", "1200..-"},
+		{"capacity=1200&capacity_tol=20%25", "capacity", "960..-"},
+		{"rpm=1500&rpm_tol=10", "rpm", "1490..1510"},
+	}
+	for _, test := range tests {
+		decodeResponse(t, serve(t, handler, http.MethodGet, hvMotorPath+"?"+test.query, nil), http.StatusOK, nil)
+		if got := bounds(repo.lastParams, test.column); got != test.bounds {
+			t.Errorf("%s: %s bounds = %s, want %s", test.query, test.column, got, test.bounds)
+		}
+		if repo.lastParams.Category != hvMotorCategory.Name {
+			t.Errorf("%s: category = %q, want %q", test.query, repo.lastParams.Category, hvMotorCategory.Name)
+		}
+	}
+
+	for _, query := range []string{"capacity_tol=5", "voltage=high", "capacity=5kV", "min_rpm=10&max_rpm=5"} {
+		decodeResponse(t, serve(t, handler, http.MethodGet, hvMotorPath+"?"+query, nil), http.StatusBadRequest, nil)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	_, handler := newTestServer(t, testAdmin,
+		testMotor(1, "Plant A", "Pump", 560, 6600, 1),
+		testMotor(2, "Plant A", "Fan", 1000, 6600, 0),
+	)
+
+	recorder := serve(t, handler, http.MethodGet, hvMotorPath+"?format=csv&sort=id", nil)
+	decodeResponse(t, recorder, http.StatusOK, nil)
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(recorder.Body.String(), "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatalf("unable to read the export: %v", err)
+	}
+	if len(records) != 3 || records[1][3] != "Pump" || records[2][3] != "Fan" {
+		t.Errorf("export = %v, want the header, Pump and Fan", records)
+	}
+}
+
+func TestStockMovements(t *testing.T) {
+	repo, handler := newTestServer(t, testAdmin, testMotor(1, "Plant A", "Pump", 560, 6600, 1))
+	path := hvMotorPath + "/1/movements"
+	// The opening balances Create records in Postgres
+	for _, location := range []string{LocationInstalled, LocationSpare} {
+		if _, err := repo.AddStockMovement(context.Background(), StockMovement{MaterialID: 1, Movement: MovementStocktake, FromLocation: LocationStocktake, ToLocation: location, Quantity: 1}, MaterialChange{}); err != nil {
+			t.Fatalf("unable to add the opening balance: %v", err)
+		}
+	}
+
+	install := map[string]interface{}{"movement": MovementInstall, "from": LocationSpare, "quantity": 1, "reason": "Bearing failure"}
+	var created StockMovementResponse
+	decodeResponse(t, serve(t, handler, http.MethodPost, path, install), http.StatusCreated, &created)
+	if created.Response.Balances[LocationSpare] != 0 || created.Response.Balances[LocationInstalled] != 2 {
+		t.Errorf("balances = %v, want 0 spare and 2 installed", created.Response.Balances)
+	}
+	if movement := created.Response.Data; movement.ToLocation != LocationInstalled || movement.Actor != testAdmin.Username {
+		t.Errorf("movement = %+v, want an install by %s", movement, testAdmin.Username)
+	}
+
+	install["quantity"] = maxStockQuantity + 1
+	decodeResponse(t, serve(t, handler, http.MethodPost, path, install), http.StatusBadRequest, nil)
+	install["quantity"], install["from"] = 1, LocationScrap
+	decodeResponse(t, serve(t, handler, http.MethodPost, path, install), http.StatusBadRequest, nil)
+
+	var movements StockMovementsResponse
+	decodeResponse(t, serve(t, handler, http.MethodGet, path, nil), http.StatusOK, &movements)
+	// The opening balances of installed and spare, then the install
+	if movements.Response.Count != 3 || movements.Response.Data[0].Movement != MovementInstall {
+		t.Errorf("movements = %+v, want the install first of 3", movements.Response.Data)
+	}
+}
+
+func TestRotorBarChecks(t *testing.T) {
+	_, handler := newTestServer(t, testAdmin, testMotor(1, "Plant A", "Pump", 560, 6600, 1))
+	path := hvMotorPath + "/1/rotor-bar-checks"
+
+	for _, check := range []RotorBarCheck{
+		{CheckDate: "2026-03-01", Status: "ok", Inspector: "Sam"},
+		{CheckDate: "2025-11-15", Status: "cracked", Inspector: "Sam"},
+	} {
+		decodeResponse(t, serve(t, handler, http.MethodPost, path, check), http.StatusCreated, nil)
+	}
+	decodeResponse(t, serve(t, handler, http.MethodPost, hvMotorPath+"/9/rotor-bar-checks", RotorBarCheck{CheckDate: "2026-03-01", Status: "OK", Inspector: "Sam"}), http.StatusNotFound, nil)
+
+	decodeResponse(t, serve(t, handler, http.MethodPost, path, RotorBarCheck{CheckDate: "2026-03-01", Status: "worn"}), http.StatusBadRequest, nil)
+
+	// Statuses are stored upper case
+	var checks RotorBarChecksResponse
+	decodeResponse(t, serve(t, handler, http.MethodGet, path, nil), http.StatusOK, &checks)
+	if checks.Response.Count != 2 || checks.Response.Data[0].Status != "CRACKED" || checks.Response.Data[1].Status != "OK" {
+		t.Errorf("checks = %+v, want CRACKED and OK", checks.Response.Data)
+	}
+}
+
+func TestStartingCurrentEvents(t *testing.T) {
+	motor := testMotor(1, "Plant A", "Pump", 560, 6600, 1)
+	motor.StartingCurrent.Frequency = FrequencyMonthly
+	repo, handler := newTestServer(t, testAdmin, motor)
+	overdue := reportsPath + "/starting-current-overdue?as_of=2026-05-01"
+
+	var report OverdueResponse
+	decodeResponse(t, serve(t, handler, http.MethodGet, overdue, nil), http.StatusOK, &report)
+	if report.Response.Count != 1 || report.Response.Data[0].Areas[0].Motors[0].Status != CheckNeverDone {
+		t.Fatalf("report = %+v, want the motor never checked", report.Response.Data)
+	}
+
+	var created StartingCurrentEventResponse
+	event := map[string]interface{}{"event": EventCheck, "event_date": "2026-04-20", "measured_current": 310.5}
+	decodeResponse(t, serve(t, handler, http.MethodPost, hvMotorPath+"/1/starting-current-events", event), http.StatusCreated, &created)
+	if created.Response.Data.MeasuredCurrent == nil || created.Response.Data.RecordedBy != testAdmin.Username {
+		t.Errorf("recorded by = %q, want %q", created.Response.Data.RecordedBy, testAdmin.Username)
+	}
+
+	// The schedule Postgres derives from the check
+	lastCheck := time.Date(2026, 4, 20, 0, 0, 0, 0, time.UTC)
+	motor.StartingCurrent.LastCheck = &lastCheck
+	repo.materials[1] = motor
+	decodeResponse(t, serve(t, handler, http.MethodGet, overdue, nil), http.StatusOK, &report)
+	if report.Response.Count != 0 {
+		t.Errorf("report = %+v, want no overdue motor after the check", report.Response.Data)
+	}
+}
+
+func TestSpareCoverage(t *testing.T) {
+	repo, handler := newTestServer(t, testAdmin)
+	pumps := MotorFamily{Voltage: 6600, Capacity: 560, RPM: 1490}
+	repo.coverage = []FamilyCoverage{
+		{Family: pumps, SpareCoverage: SpareCoverage{Motors: 2, Installed: 2, Spare: 1}, Plants: []PlantCoverage{
+			{Plant: "Plant A", SpareCoverage: SpareCoverage{Motors: 1, Installed: 1, Spare: 1}, MaterialIDs: []int{1}},
+			{Plant: "Plant B", SpareCoverage: SpareCoverage{Motors: 1, Installed: 1}, MaterialIDs: []int{2}},
+		}},
+		{Family: MotorFamily{Voltage: 6600, Capacity: 1000, RPM: 1490}, SpareCoverage: SpareCoverage{Motors: 1, Installed: 1}, Plants: []PlantCoverage{
+			{Plant: "Plant A", SpareCoverage: SpareCoverage{Motors: 1, Installed: 1}, MaterialIDs: []int{3}},
+		}},
+	}
+	repo.unclassified = 1
+
+	var report SpareCoverageResponse
+	decodeResponse(t, serve(t, handler, http.MethodGet, reportsPath+"/spare-coverage", nil), http.StatusOK, &report)
+	if report.Response.Unclassified != 1 || report.Response.Count != 2 {
+		t.Fatalf("report = %+v, want 2 families and 1 unclassified motor", report.Response)
+	}
+	// The fan family has no spare, so it comes first
+	fan, pump := report.Response.Data[0], report.Response.Data[1]
+	if fan.Family.Capacity != 1000 || len(fan.Flags) == 0 || fan.Flags[0] != FlagNoSpare {
+		t.Errorf("first family = %+v, want the fan flagged no_spare", fan)
+	}
+	if pump.Family != pumps || pump.SpareRatio == nil || *pump.SpareRatio != 0.5 || len(pump.Flags) != 0 {
+		t.Errorf("second family = %+v, want the pumps unflagged at a ratio of 0.5", pump)
+	}
+	if plant := pump.Plants[1]; len(plant.Flags) == 0 || plant.Flags[0] != FlagNoSpare {
+		t.Errorf("Plant B = %+v, want it flagged no_spare", plant)
+	}
+
+	decodeResponse(t, serve(t, handler, http.MethodGet, reportsPath+"/spare-coverage?flagged=true&min_ratio=0.6", nil), http.StatusOK, &report)
+	if report.Response.Count != 2 || report.Response.Data[1].Flags[0] != FlagLowSpareRatio {
+		t.Errorf("report = %+v, want the pumps flagged low_spare_ratio under 0.6", report.Response.Data)
+	}
+}
+
+func TestAuditFilters(t *testing.T) {
+	repo, handler := newTestServer(t, testAdmin)
+	for id := int64(1); id <= 3; id++ {
+		repo.audit = append(repo.audit, AuditEntry{ID: id, Actor: testAdmin.Username, Action: "update"})
+	}
+
+	var page AuditResponse
+	decodeResponse(t, serve(t, handler, http.MethodGet, auditPath+"?actor=admin&since=2026-01-01&limit=2", nil), http.StatusOK, &page)
+	if page.Response.Total != 3 || page.Response.Count != 2 || page.Response.Next == nil {
+		t.Errorf("page = %+v, want 2 of 3 entries and a next page", page.Response)
+	}
+	if filter := repo.lastAuditFilter; filter.Actor != "admin" || filter.Since.Format("2006-01-02") != "2026-01-01" || filter.Plants != nil {
+		t.Errorf("filter = %+v, want admin since 2026-01-01 on every plant", filter)
+	}
+	decodeResponse(t, serve(t, handler, http.MethodGet, auditPath+"?since=yesterday", nil), http.StatusBadRequest, nil)
+}
+
+func TestAuditPlantScope(t *testing.T) {
+	plantViewer := User{ID: 3, Username: "plant-a", Roles: []PlantRole{{Plant: "plant a", Role: RoleViewer}}}
+	repo, handler := newTestServer(t, plantViewer, testMotor(2, "Plant B", "Fan", 1000, 6600, 0))
+
+	// A plant role only reads the entries of its plants, by history or by the audit log
+	decodeResponse(t, serve(t, handler, http.MethodGet, auditPath, nil), http.StatusOK, nil)
+	if filter := repo.lastAuditFilter; strings.Join(filter.Plants, ",") != "plant a" {
+		t.Errorf("audit filter = %+v, want plant a only", filter)
+	}
+	decodeResponse(t, serve(t, handler, http.MethodGet, hvMotorPath+"/2/history", nil), http.StatusOK, nil)
+	if filter := repo.lastAuditFilter; filter.MaterialID != 2 || strings.Join(filter.Plants, ",") != "plant a" {
+		t.Errorf("history filter = %+v, want material 2 on plant a only", filter)
+	}
+}
+
+func TestStartingCurrentOverdueScope(t *testing.T) {
+	repo, handler := newTestServer(t, testAdmin)
+
+	// The report only asks for HV motors, on the plant and area given
+	for query, want := range map[string]string{"": "", "&plant=Plant%20A": "plant=Plant A", "&plant=Plant%20A&area=Substation%202": "plant=Plant A,area=Substation 2"} {
+		decodeResponse(t, serve(t, handler, http.MethodGet, reportsPath+"/starting-current-overdue?as_of=2026-05-01"+query, nil), http.StatusOK, nil)
+		var texts []string
+		for _, text := range repo.lastParams.Texts {
+			texts = append(texts, text.Column+"="+text.Value)
+		}
+		if repo.lastParams.Category != hvMotorCategory.Name || strings.Join(texts, ",") != want {
+			t.Errorf("%q: params = %+v, want HV Motor with %s", query, repo.lastParams, want)
+		}
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	disabled := User{ID: 5, Username: "left", Disabled: true, Roles: testAdmin.Roles}
+	_, routes := newTestRoutes(t, []User{testAdmin, disabled}, testMotor(1, "Plant A", "Pump", 560, 6600, 1))
+
+	tests := []struct {
+		authorization string
+		status        int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer not-a-token", http.StatusUnauthorized},
+		{"Bearer " + testToken(t, 99), http.StatusUnauthorized},
+		{"Bearer " + testToken(t, disabled.ID), http.StatusUnauthorized},
+		{"Bearer " + testToken(t, testAdmin.ID), http.StatusOK},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, hvMotorPath+"/1", nil)
+		if test.authorization != "" {
+			request.Header.Set("Authorization", test.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("%q: status = %d, want %d", test.authorization, recorder.Code, test.status)
+		}
+	}
+}
+
+func TestLoginAndUsers(t *testing.T) {
+	_, routes := newTestRoutes(t, []User{testAdmin})
+	handler := signedIn(routes, testToken(t, testAdmin.ID))
+
+	newUser := NewUser{Username: " Planner ", Password: "correct horse battery", Roles: []PlantRole{{Plant: "plant a", Role: RolePlanner}}}
+	var created UserResponse
+	decodeResponse(t, serve(t, handler, http.MethodPost, authPath+"/users", newUser), http.StatusCreated, &created)
+	if created.Response.Data.Username != "planner" || created.Response.Data.Roles[0].Plant != "PLANT A" {
+		t.Fatalf("created = %+v, want planner on PLANT A", created.Response.Data)
+	}
+	decodeResponse(t, serve(t, handler, http.MethodPost, authPath+"/users", newUser), http.StatusConflict, nil)
+	newUser.Password = "short"
+	decodeResponse(t, serve(t, handler, http.MethodPost, authPath+"/users", newUser), http.StatusBadRequest, nil)
+
+	decodeResponse(t, serve(t, handler, http.MethodPost, authPath+"/login", LoginRequest{Username: "planner", Password: "wrong password"}), http.StatusUnauthorized, nil)
+	var login LoginResponse
+	decodeResponse(t, serve(t, handler, http.MethodPost, authPath+"/login", LoginRequest{Username: "PLANNER", Password: "correct horse battery"}), http.StatusOK, &login)
+
+	planner := signedIn(routes, login.Response.Token)
+	decodeResponse(t, serve(t, planner, http.MethodGet, authPath+"/users", nil), http.StatusForbidden, nil)
+	var me UserResponse
+	decodeResponse(t, serve(t, planner, http.MethodGet, authPath+"/me", nil), http.StatusOK, &me)
+	if me.Response.Data.ID != created.Response.Data.ID {
+		t.Errorf("me = %+v, want the created user", me.Response.Data)
+	}
+}
+
+// Function to post a CSV file to the import route with a query string and decode the report
+func serveImport(t *testing.T, handler http.Handler, query, file string, status int) ImportReport {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodPost, importsPath+"?"+query, strings.NewReader(file))
+	request.Header.Set("Content-Type", "text/csv")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	var response ImportResponse
+	decodeResponse(t, recorder, status, &response)
+	return response.Response.Data
+}
+
+func TestImportCategories(t *testing.T) {
+	_, handler := newTestServer(t, testAdmin)
+
+	profile := ImportProfile{Category: "lv-motor", Mapping: map[string]string{
+		"plant": "Plant", "area": "MCC", "name": "Tag", "capacity": "kW", "category": "Kind",
+		"specs.mounting": "Mounting", "specs.rating": "Rating",
+	}}
+	var saved ImportProfileResponse
+	decodeResponse(t, serve(t, handler, http.MethodPut, importsPath+"/profiles/mcc", profile), http.StatusOK, &saved)
+	if saved.Response.Data.Category != "LV Motor" {
+		t.Fatalf("profile category = %q, want LV Motor", saved.Response.Data.Category)
+	}
+	profile.Category = "Switchgear"
+	decodeResponse(t, serve(t, handler, http.MethodPut, importsPath+"/profiles/mcc", profile), http.StatusBadRequest, nil)
+
+	file := "Plant,MCC,Tag,kW,Kind,Mounting,Rating\n" +
+		"Plant A,MCC 1,P-101,55 kW,,B3,\n" +
+		"Plant A,MCC 1,TR-1,,Transformer,,2.5 MVA\n" +
+		"Plant A,MCC 1,TR-2,,transformer,B3,\n"
+	report := serveImport(t, handler, "profile=mcc&commit=true", file, http.StatusUnprocessableEntity)
+	// A transformer has no mounting
+	if report.Summary.Invalid != 1 || report.Rows[2].Issues[0].Field != "specs.mounting" {
+		t.Fatalf("report = %+v, want the mounting of TR-2 rejected", report)
+	}
+
+	report = serveImport(t, handler, "profile=mcc&commit=true&skip_invalid=true", file, http.StatusOK)
+	if report.Summary.Created != 2 {
+		t.Fatalf("summary = %+v, want 2 created", report.Summary)
+	}
+
+	var motor MaterialResponse
+	decodeResponse(t, serve(t, handler, http.MethodGet, materialsPath+"/lv-motor/1", nil), http.StatusOK, &motor)
+	if specs := motor.Response.Data.Specs; specs["capacity"] != 55.0 || specs["mounting"] != "B3" || motor.Response.Data.Specifications.Capacity != 0 {
+		t.Errorf("LV motor = %+v, want capacity and mounting in specs", motor.Response.Data)
+	}
+	var transformer MaterialResponse
+	decodeResponse(t, serve(t, handler, http.MethodGet, materialsPath+"/transformer/2", nil), http.StatusOK, &transformer)
+	if rating := transformer.Response.Data.Specs["rating"]; rating != 2500.0 {
+		t.Errorf("transformer rating = %v, want 2500 kVA", rating)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// healthzPath and readyzPath are probed by Docker and load balancers, outside /api like metricsPath
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+)
+
+// readinessTimeout bounds every readiness probe, a database that does not answer in time is not ready
+const readinessTimeout = 2 * time.Second
+
+// Backoff of the initial connection attempts, doubling from the first to the longest
+const (
+	connectFirstBackoff = 500 * time.Millisecond
+	connectMaxBackoff   = 15 * time.Second
+)
+
+// HealthCheck is the outcome of one readiness check, Error is set when it failed
+type HealthCheck struct {
+	Name   string      `json:"name"`
+	OK     bool        `json:"ok"`
+	Error  string      `json:"error,omitempty"`
+	Detail interface{} `json:"detail,omitempty"`
+}
+
+// PoolSaturation is how much of the connection pool is in use
+type PoolSaturation struct {
+	AcquiredConns int32   `json:"acquired_conns"`
+	IdleConns     int32   `json:"idle_conns"`
+	TotalConns    int32   `json:"total_conns"`
+	MaxConns      int32   `json:"max_conns"`
+	Saturation    float64 `json:"saturation"`
+	// EmptyAcquires counts, since startup, the requests that had to wait for a connection
+	EmptyAcquires int64 `json:"empty_acquires"`
+}
+
+type HealthResponse struct {
+	Response struct {
+		Success bool          `json:"success"`
+		Status  string        `json:"status"`
+		Checks  []HealthCheck `json:"checks,omitempty"`
+	} `json:"response"`
+}
+
+// Function to report that the process is up, it never touches the database
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var response HealthResponse
+	response.Response.Success = true
+	response.Response.Status = "ok"
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Function to report whether requests can be served: the database answers and its schema is current.
+// Pool saturation is reported but never fails the probe, taking every busy instance out of rotation would only add to the load.
+func getReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := []HealthCheck{checkDatabase(ctx, db), checkMigrations(ctx, db), checkPool(db)}
+
+	var response HealthResponse
+	response.Response.Success = true
+	response.Response.Status = "ready"
+	response.Response.Checks = checks
+	for _, check := range checks {
+		if !check.OK {
+			response.Response.Success = false
+			response.Response.Status = "not ready"
+		}
+	}
+
+	status := http.StatusOK
+	if !response.Response.Success {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, response)
+}
+
+func checkDatabase(ctx context.Context, db *pgxpool.Pool) HealthCheck {
+	check := HealthCheck{Name: "database", OK: true}
+	if err := db.Ping(ctx); err != nil {
+		check.OK, check.Error = false, fmt.Sprintf("unable to ping the database: %v", err)
+	}
+	return check
+}
+
+// Function to check that every embedded migration is applied, without creating schema_migrations like migrationStatus
+func checkMigrations(ctx context.Context, db *pgxpool.Pool) HealthCheck {
+	check := HealthCheck{Name: "migrations", OK: true}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		check.OK, check.Error = false, err.Error()
+		return check
+	}
+	applied, err := selectAppliedMigrations(ctx, db)
+	if err != nil {
+		check.OK, check.Error = false, err.Error()
+		return check
+	}
+
+	pending := 0
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending++
+		}
+	}
+	if pending > 0 {
+		check.OK, check.Error = false, fmt.Sprintf("%d migrations are pending, run migrate up or set MIGRATE_ON_START=true", pending)
+	}
+	check.Detail = map[string]int{"applied": len(applied), "pending": pending}
+	return check
+}
+
+func checkPool(db *pgxpool.Pool) HealthCheck {
+	stat := db.Stat()
+	saturation := PoolSaturation{
+		AcquiredConns: stat.AcquiredConns(),
+		IdleConns:     stat.IdleConns(),
+		TotalConns:    stat.TotalConns(),
+		MaxConns:      stat.MaxConns(),
+		EmptyAcquires: stat.EmptyAcquireCount(),
+	}
+	if saturation.MaxConns > 0 {
+		saturation.Saturation = float64(saturation.AcquiredConns) / float64(saturation.MaxConns)
+	}
+	return HealthCheck{Name: "pool", OK: true, Detail: saturation}
+}
+
+// Function to open the pool and wait until the database answers, retrying with a doubling backoff for up to timeout.
+// pgxpool.NewWithConfig connects lazily, so without the ping a backend started before Postgres would serve 500s.
+func connectDatabase(ctx context.Context, config *pgxpool.Config, timeout time.Duration) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	backoff := connectFirstBackoff
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		err = pool.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			return pool, nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			pool.Close()
+			return nil, fmt.Errorf("database not reachable after %d attempts: %w", attempt, err)
+		}
+		logf(levelWarn, "Database not reachable (attempt %d), retrying in %s: %v", attempt, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			pool.Close()
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > connectMaxBackoff {
+			backoff = connectMaxBackoff
+		}
+	}
+}
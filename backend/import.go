@@ -0,0 +1,1410 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// importsPath is the route of spreadsheet imports and their mapping profiles
+const importsPath = "/api/v1/intools/electra/imports"
+
+// maxImportSize is the largest spreadsheet an import accepts
+const maxImportSize = 20 << 20
+
+// defaultProfile is the built-in mapping of the data.csv layout, it cannot be overwritten
+const defaultProfile = "default"
+
+// Severities of an import issue, a single error keeps the whole file from being committed unless invalid rows are skipped
+const (
+	IssueError   = "error"
+	IssueWarning = "warning"
+)
+
+// Outcomes of an imported row besides AuditCreate, AuditUpdate and AuditDelete
+const (
+	ImportUnchanged = "unchanged"
+	ImportInvalid   = "invalid"
+)
+
+// placeholder is what the spreadsheets hold in place of an unknown value
+const placeholder = "-"
+
+// importField is a material field a spreadsheet column can be mapped to, exactly one target is set
+type importField struct {
+	Name string
+	// Header is the normalised header of the column in the default profile, "" when it has none
+	Header   string
+	Required bool
+	Text     func(material *Material) *string
+	Number   func(material *Material) *int
+	Decimal  func(material *Material) *float64
+	Quantity func(material *Material) *int
+	Date     func(material *Material) **time.Time
+	// Spec is the name of an attribute stored in specs, the cell is parsed once the category of the row is known
+	Spec string
+	// Check marks the columns of a rotor bar check, they are recorded as a check rather than written to the material
+	Check bool
+}
+
+// importFields are the fields in the column order of data.csv, "#2" picks the second column of a repeated header,
+// followed by a specs.<name> field for every attribute a category stores in specs
+var importFields = append([]importField{
+	{Name: "plant", Header: "plant", Required: true, Text: func(m *Material) *string { return &m.Plant }},
+	{Name: "area", Header: "electrical room", Required: true, Text: func(m *Material) *string { return &m.Area }},
+	{Name: "name", Header: "motor name", Required: true, Text: func(m *Material) *string { return &m.Name }},
+	{Name: "capacity", Header: "capacity kw", Decimal: func(m *Material) *float64 { return &m.Specifications.Capacity }},
+	{Name: "voltage", Header: "voltage v", Decimal: func(m *Material) *float64 { return &m.Specifications.Voltage }},
+	{Name: "current", Header: "current a", Decimal: func(m *Material) *float64 { return &m.Specifications.Current }},
+	{Name: "rpm", Header: "rpm", Decimal: func(m *Material) *float64 { return &m.Specifications.RPM }},
+	{Name: "maker", Header: "maker", Text: func(m *Material) *string { return &m.Maker }},
+	{Name: "serial_number", Header: "serial number", Text: func(m *Material) *string { return &m.SerialNumber }},
+	{Name: "starting_current_when", Header: "when", Text: func(m *Material) *string { return &m.StartingCurrent.When }},
+	{Name: "starting_current_check", Header: "check", Text: func(m *Material) *string { return &m.StartingCurrent.Check }},
+	{Name: "rotor_bar_check_date", Header: "check date", Check: true, Date: func(m *Material) **time.Time { return &m.RotorBar.CheckDate }},
+	{Name: "rotor_bar_check_status", Header: "check status", Check: true, Text: func(m *Material) *string { return &m.RotorBar.CheckStatus }},
+	{Name: "rotor_bar_reason", Header: "reason", Check: true, Text: func(m *Material) *string { return &m.RotorBar.Reason }},
+	{Name: "rotor_bar_remark", Header: "remark#1", Check: true, Text: func(m *Material) *string { return &m.RotorBar.Remark }},
+	{Name: "frame", Header: "frame", Number: func(m *Material) *int { return &m.Frame }},
+	{Name: "type", Header: "type", Text: func(m *Material) *string { return &m.Type }},
+	{Name: "installed_qty", Header: "installed qty", Quantity: func(m *Material) *int { return &m.Installed }},
+	{Name: "standby_qty", Header: "standby", Quantity: func(m *Material) *int { return &m.StandBy }},
+	{Name: "spare_qty", Header: "spare", Quantity: func(m *Material) *int { return &m.Spare }},
+	{Name: "shaft_diameter", Header: "shaft dia", Decimal: func(m *Material) *float64 { return &m.Size.ShaftDiameter }},
+	{Name: "base_width", Header: "base width", Decimal: func(m *Material) *float64 { return &m.Size.BaseWidth }},
+	{Name: "base_length", Header: "base length", Decimal: func(m *Material) *float64 { return &m.Size.BaseLength }},
+	{Name: "c", Header: "c", Decimal: func(m *Material) *float64 { return &m.Size.C }},
+	{Name: "e", Header: "e", Decimal: func(m *Material) *float64 { return &m.Size.E }},
+	{Name: "h", Header: "h", Decimal: func(m *Material) *float64 { return &m.Size.H }},
+	{Name: "operation", Header: "operation", Text: func(m *Material) *string { return &m.Operation }},
+	{Name: "remark", Header: "remark#2", Text: func(m *Material) *string { return &m.Remark }},
+	{Name: "qcode", Text: func(m *Material) *string { return &m.QCode }},
+	{Name: "pic_team", Text: func(m *Material) *string { return &m.PIC.Team }},
+	{Name: "pic_name", Text: func(m *Material) *string { return &m.PIC.Name }},
+	{Name: "pic_phone", Text: func(m *Material) *string { return &m.PIC.Phone }},
+	{Name: "pic_email", Text: func(m *Material) *string { return &m.PIC.Email }},
+	{Name: "category", Text: func(m *Material) *string { return &m.Category }},
+}, specImportFields()...)
+
+// Function to declare an import field for every attribute stored in specs, once per name across the categories
+func specImportFields() []importField {
+	var fields []importField
+	declared := map[string]bool{}
+	for _, category := range categories {
+		for _, attribute := range category.Attributes {
+			if attribute.inSpecs() && !declared[attribute.Name] {
+				declared[attribute.Name] = true
+				fields = append(fields, importField{Name: "specs." + attribute.Name, Spec: attribute.Name})
+			}
+		}
+	}
+	return fields
+}
+
+// ImportProfile maps material fields to the normalised headers of their columns, fields left out are not imported.
+// Category is the category of the new materials of rows without a category column.
+type ImportProfile struct {
+	Name      string            `json:"name"`
+	Category  string            `json:"category"`
+	Mapping   map[string]string `json:"mapping"`
+	UpdatedBy string            `json:"updated_by"`
+	UpdatedAt *time.Time        `json:"updated_at"`
+}
+
+// ImportIssue is one problem found in a cell, or in the header row when Row is 1
+type ImportIssue struct {
+	Row      int    `json:"row"`
+	Column   string `json:"column"`
+	Field    string `json:"field"`
+	Value    string `json:"value"`
+	Severity string `json:"severity"`
+	Problem  string `json:"problem"`
+}
+
+// ImportRow is the outcome of one spreadsheet row, Row is its number as Excel shows it and 0 for a pruned material
+type ImportRow struct {
+	Row        int           `json:"row"`
+	Key        string        `json:"key"`
+	MaterialID int           `json:"material_id"`
+	Action     string        `json:"action"`
+	Changes    []FieldChange `json:"changes"`
+	Issues     []ImportIssue `json:"issues"`
+
+	material Material
+	stored   *Material
+	// specs holds the cells of the specs.<name> fields by attribute name
+	specs map[string]string
+	// check is the rotor bar check the row adds, nil when it holds none newer than the stored status
+	check *RotorBarCheck
+}
+
+type ImportSummary struct {
+	Rows      int `json:"rows"`
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+	Invalid   int `json:"invalid"`
+	Errors    int `json:"errors"`
+	Warnings  int `json:"warnings"`
+}
+
+// ImportReport is the validation report of a spreadsheet, Committed tells whether it was written
+type ImportReport struct {
+	Profile   string `json:"profile"`
+	Committed bool   `json:"committed"`
+	// Columns maps each imported field to the header of its column as written in the file
+	Columns map[string]string `json:"columns"`
+	Ignored []string          `json:"ignored"`
+	// Issues are the problems of the header row, each row lists its own
+	Issues  []ImportIssue `json:"issues"`
+	Summary ImportSummary `json:"summary"`
+	Rows    []ImportRow   `json:"rows"`
+}
+
+type ImportResponse struct {
+	Request struct {
+		Profile     string `json:"profile"`
+		Commit      bool   `json:"commit"`
+		Prune       bool   `json:"prune"`
+		SkipInvalid bool   `json:"skip_invalid"`
+		Filename    string `json:"filename"`
+	} `json:"request"`
+	Response struct {
+		Success bool         `json:"success"`
+		Data    ImportReport `json:"data"`
+	} `json:"response"`
+}
+
+type ImportProfilesResponse struct {
+	Response struct {
+		Count   int             `json:"count"`
+		Success bool            `json:"success"`
+		Data    []ImportProfile `json:"data"`
+	} `json:"response"`
+}
+
+type ImportProfileResponse struct {
+	Response struct {
+		Success bool          `json:"success"`
+		Data    ImportProfile `json:"data"`
+	} `json:"response"`
+}
+
+// importOptions controls how runImport reads and writes a spreadsheet
+type importOptions struct {
+	Profile ImportProfile
+	Commit  bool
+	// Prune deletes the imported materials whose key is no longer in the file
+	Prune bool
+	// SkipInvalid commits the valid rows of a file that has invalid ones, which are reported and left as they are.
+	// An error in the header row still keeps the whole file from being committed.
+	SkipInvalid bool
+	Actor       string
+	// Endpoint is recorded in the audit log, the route or the file of a command line run
+	Endpoint string
+	// Allowed reports whether the actor may change materials of a plant, nil allows every plant
+	Allowed func(plant string) bool
+}
+
+var errProfileNotFound = errors.New("import profile not found")
+
+// ImportRepository stores the import profiles and writes imports, the import handlers reach it only through importRepo
+type ImportRepository interface {
+	// Profile returns the built-in default or a saved profile, errProfileNotFound when name does not exist
+	Profile(ctx context.Context, name string) (ImportProfile, error)
+	// Profiles returns the built-in default followed by the saved profiles by name
+	Profiles(ctx context.Context) ([]ImportProfile, error)
+	// SaveProfile creates or replaces a profile checked by prepareImportProfile
+	SaveProfile(ctx context.Context, profile ImportProfile, actor string) (ImportProfile, error)
+	// Run validates the rows of a spreadsheet against the stored materials with validateImport and writes them
+	// when options allow it, no other writer can change the materials in between
+	Run(ctx context.Context, rows [][]string, options importOptions) (ImportReport, error)
+}
+
+// pgImportRepository stores profiles in import_profiles and imports into list_materials and the tables referencing it
+type pgImportRepository struct {
+	db *pgxpool.Pool
+}
+
+func newPgImportRepository(db *pgxpool.Pool) *pgImportRepository {
+	return &pgImportRepository{db: db}
+}
+
+func (repo *pgImportRepository) Profile(ctx context.Context, name string) (ImportProfile, error) {
+	return selectImportProfile(ctx, repo.db, name)
+}
+
+func (repo *pgImportRepository) Profiles(ctx context.Context) ([]ImportProfile, error) {
+	return selectImportProfiles(ctx, repo.db)
+}
+
+func (repo *pgImportRepository) SaveProfile(ctx context.Context, profile ImportProfile, actor string) (ImportProfile, error) {
+	return upsertImportProfile(ctx, repo.db, profile, actor)
+}
+
+func (repo *pgImportRepository) Run(ctx context.Context, rows [][]string, options importOptions) (ImportReport, error) {
+	return runImport(ctx, repo.db, rows, options)
+}
+
+// Function to dispatch /imports, /imports/profiles and /imports/profiles/{name}
+func importRoutes(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, importsPath), "/")
+	switch {
+	case rest == "" && r.Method == http.MethodPost:
+		importMaterials(w, r)
+	case rest == "profiles" && r.Method == http.MethodGet:
+		getImportProfiles(w, r)
+	case strings.HasPrefix(rest, "profiles/") && r.Method == http.MethodGet:
+		getImportProfile(w, r, strings.TrimPrefix(rest, "profiles/"))
+	case strings.HasPrefix(rest, "profiles/") && r.Method == http.MethodPut:
+		saveImportProfile(w, r, strings.TrimPrefix(rest, "profiles/"))
+	case rest == "" || rest == "profiles" || strings.HasPrefix(rest, "profiles/"):
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Function to validate a CSV or XLSX upload against ?profile= and write it when ?commit=true and nothing is wrong,
+// or only its valid rows with ?skip_invalid=true. ?prune=true also deletes the imported materials that are no longer
+// in the file. The flags match those of the import command. The file is the "file" part of a multipart form or the raw request body.
+func importMaterials(w http.ResponseWriter, r *http.Request) {
+	query := newQueryParser(r)
+	commit, prune, skipInvalid := query.bool("commit"), query.bool("prune"), query.bool("skip_invalid")
+	if !query.valid(w) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	data, filename, err := readUpload(r)
+	if err != nil {
+		writeInputError(w, InputError{Field: "file", Reason: err.Error()})
+		return
+	}
+	rows, err := readSpreadsheet(data)
+	if err != nil {
+		writeInputError(w, InputError{Field: "file", Value: filename, Reason: err.Error()})
+		return
+	}
+
+	name := r.URL.Query().Get("profile")
+	if name == "" {
+		name = defaultProfile
+	}
+	profile, err := importRepo.Profile(r.Context(), name)
+	if errors.Is(err, errProfileNotFound) {
+		http.Error(w, fmt.Sprintf("Import profile %s not found", name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting import profile: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	user := currentUser(r)
+	options := importOptions{
+		Profile:     profile,
+		Commit:      commit,
+		Prune:       prune,
+		SkipInvalid: skipInvalid,
+		Actor:       user.Username,
+		Endpoint:    r.Method + " " + r.URL.Path,
+		Allowed:     func(plant string) bool { return user.can(plant, RolePlanner) },
+	}
+	report, err := importRepo.Run(r.Context(), rows, options)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error importing materials: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response ImportResponse
+	response.Request.Profile = profile.Name
+	response.Request.Commit = options.Commit
+	response.Request.Prune = options.Prune
+	response.Request.SkipInvalid = options.SkipInvalid
+	response.Request.Filename = filename
+	response.Response.Data = report
+
+	// A commit that was refused still returns the report, so the file can be fixed
+	status := http.StatusOK
+	if options.Commit && !report.Committed {
+		status = http.StatusUnprocessableEntity
+	}
+	response.Response.Success = status == http.StatusOK
+
+	writeJSON(w, status, response)
+}
+
+// Function to read the uploaded file and its name, "" when it was sent as the raw body
+func readUpload(r *http.Request) ([]byte, string, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", fmt.Errorf("the form must have a file field: %w", err)
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		return data, header.Filename, err
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err == nil && len(data) == 0 {
+		err = errors.New("the body is empty")
+	}
+	return data, "", err
+}
+
+func getImportProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := importRepo.Profiles(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting import profiles: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response ImportProfilesResponse
+	response.Response.Count = len(profiles)
+	response.Response.Success = true
+	response.Response.Data = profiles
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func getImportProfile(w http.ResponseWriter, r *http.Request, name string) {
+	profile, err := importRepo.Profile(r.Context(), name)
+	if errors.Is(err, errProfileNotFound) {
+		http.Error(w, fmt.Sprintf("Import profile %s not found", name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting import profile: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeImportProfile(w, http.StatusOK, profile)
+}
+
+// Function to create or replace a saved profile. Profiles are shared by every plant,
+// so only maintenance admins of every plant can change them.
+func saveImportProfile(w http.ResponseWriter, r *http.Request, name string) {
+	if !authorize(w, r, RoleMaintenanceAdmin, allPlants) {
+		return
+	}
+
+	var profile ImportProfile
+	if err := decodeJSON(r, &profile); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	if profile.Name != "" && profile.Name != name {
+		writeInputError(w, InputError{Field: "name", Value: profile.Name, Reason: fmt.Sprintf("does not match the path name %q", name)})
+		return
+	}
+	profile.Name = name
+	if err := prepareImportProfile(&profile); err != nil {
+		writeInputError(w, err)
+		return
+	}
+
+	saved, err := importRepo.SaveProfile(r.Context(), profile, currentUser(r).Username)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error saving import profile: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeImportProfile(w, http.StatusOK, saved)
+}
+
+func writeImportProfile(w http.ResponseWriter, status int, profile ImportProfile) {
+	var response ImportProfileResponse
+	response.Response.Success = true
+	response.Response.Data = profile
+
+	writeJSON(w, status, response)
+}
+
+// Function to check the name and fields of a profile and normalise its headers, the problems are returned as InputErrors
+func prepareImportProfile(profile *ImportProfile) error {
+	var problems InputErrors
+	profile.Name = strings.TrimSpace(profile.Name)
+	if profile.Name == "" || strings.Contains(profile.Name, "/") {
+		problems = append(problems, InputError{Field: "name", Value: profile.Name, Reason: "must be set and must not contain /"})
+	}
+	if profile.Name == defaultProfile {
+		problems = append(problems, InputError{Field: "name", Value: profile.Name, Reason: "is the built-in profile and cannot be changed"})
+	}
+
+	category, ok := importCategory(profile.Category)
+	if profile.Category == "" {
+		category, ok = hvMotorCategory, true
+	}
+	if !ok {
+		problems = append(problems, InputError{Field: "category", Value: profile.Category, Reason: "is not a known category"})
+	}
+
+	known := map[string]bool{}
+	for _, field := range importFields {
+		known[field.Name] = true
+	}
+	mapping := map[string]string{}
+	for field, header := range profile.Mapping {
+		header = normalizeMappedHeader(header)
+		switch {
+		case !known[field]:
+			problems = append(problems, InputError{Field: "mapping." + field, Value: header, Reason: "is not a field that can be imported"})
+		case header == "":
+			problems = append(problems, InputError{Field: "mapping." + field, Reason: "must name a header"})
+		default:
+			mapping[field] = header
+		}
+	}
+	for _, field := range importFields {
+		if field.Required && profile.Mapping[field.Name] == "" {
+			problems = append(problems, InputError{Field: "mapping." + field.Name, Reason: "is required"})
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Field < problems[j].Field })
+
+	if len(problems) > 0 {
+		return problems
+	}
+	profile.Category = category.Name
+	profile.Mapping = mapping
+	return nil
+}
+
+// Function to find a category by its name or its slug, in any case, like a spreadsheet cell may hold either
+func importCategory(value string) (Category, bool) {
+	value = strings.TrimSpace(value)
+	for _, category := range categories {
+		if strings.EqualFold(category.Name, value) || strings.EqualFold(category.Slug, value) {
+			return category, true
+		}
+	}
+	return Category{}, false
+}
+
+// Function to normalise a mapped header, keeping the #n suffix that picks a repeated column
+func normalizeMappedHeader(header string) string {
+	base, occurrence := splitOccurrence(header)
+	if occurrence == 0 {
+		return normalizeHeader(header)
+	}
+	return normalizeHeader(base) + "#" + strconv.Itoa(occurrence)
+}
+
+// Function to split "remark#2" into "remark" and 2, the occurrence is 0 without a numeric suffix
+func splitOccurrence(header string) (string, int) {
+	i := strings.LastIndex(header, "#")
+	if i < 0 {
+		return header, 0
+	}
+	occurrence, err := strconv.Atoi(strings.TrimSpace(header[i+1:]))
+	if err != nil || occurrence <= 0 {
+		return header, 0
+	}
+	return header[:i], occurrence
+}
+
+// Function to return the built-in mapping of the data.csv layout
+func defaultImportProfile() ImportProfile {
+	profile := ImportProfile{Name: defaultProfile, Category: hvMotorCategory.Name, Mapping: map[string]string{}}
+	for _, field := range importFields {
+		if field.Header != "" {
+			profile.Mapping[field.Name] = field.Header
+		}
+	}
+	return profile
+}
+
+// Function to select a profile by name, the built-in default included
+func selectImportProfile(ctx context.Context, db querier, name string) (ImportProfile, error) {
+	if name == defaultProfile {
+		return defaultImportProfile(), nil
+	}
+
+	profile, err := scanImportProfile(db.QueryRow(ctx,
+		"SELECT name, category, mapping, updated_by, updated_at FROM public.import_profiles WHERE name = $1", name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ImportProfile{}, errProfileNotFound
+	}
+	if err != nil {
+		return ImportProfile{}, fmt.Errorf("unable to select import profile: %w", err)
+	}
+	return profile, nil
+}
+
+// Function to list the built-in default followed by the saved profiles by name
+func selectImportProfiles(ctx context.Context, db querier) ([]ImportProfile, error) {
+	rows, err := db.Query(ctx, "SELECT name, category, mapping, updated_by, updated_at FROM public.import_profiles ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	profiles := []ImportProfile{defaultImportProfile()}
+	for rows.Next() {
+		profile, err := scanImportProfile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+		profiles = append(profiles, profile)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	return profiles, nil
+}
+
+func upsertImportProfile(ctx context.Context, db querier, profile ImportProfile, actor string) (ImportProfile, error) {
+	return scanImportProfile(db.QueryRow(ctx,
+		`INSERT INTO public.import_profiles (name, category, mapping, updated_by, updated_at)
+		VALUES(@name, @category, @mapping, @updated_by, now())
+		ON CONFLICT (name) DO UPDATE SET category = EXCLUDED.category, mapping = EXCLUDED.mapping, updated_by = EXCLUDED.updated_by, updated_at = now()
+		RETURNING name, category, mapping, updated_by, updated_at`,
+		pgx.NamedArgs{"name": profile.Name, "category": profile.Category, "mapping": profile.Mapping, "updated_by": actor}))
+}
+
+func scanImportProfile(row pgx.Row) (ImportProfile, error) {
+	var profile ImportProfile
+	err := row.Scan(&profile.Name, &profile.Category, &profile.Mapping, &profile.UpdatedBy, &profile.UpdatedAt)
+	return profile, err
+}
+
+// importColumn ties a mapped field to its column in the file
+type importColumn struct {
+	Field  importField
+	Index  int
+	Header string
+}
+
+// Function to find the column of every mapped field in the header row
+func mapImportColumns(header []string, profile ImportProfile) ([]importColumn, []string, []ImportIssue) {
+	// Each header is reachable by its name, its first occurrence, and name#n for the nth occurrence
+	positions := map[string]int{}
+	occurrences := map[string]int{}
+	for i, cell := range header {
+		name := normalizeHeader(cell)
+		if name == "" {
+			continue
+		}
+		occurrences[name]++
+		positions[name+"#"+strconv.Itoa(occurrences[name])] = i
+		if occurrences[name] == 1 {
+			positions[name] = i
+		}
+	}
+
+	var columns []importColumn
+	var issues []ImportIssue
+	used := map[int]bool{}
+	for _, field := range importFields {
+		mapped, ok := profile.Mapping[field.Name]
+		if !ok {
+			continue
+		}
+		index, found := positions[mapped]
+		if !found {
+			issue := ImportIssue{Row: 1, Field: field.Name, Value: mapped, Severity: IssueWarning, Problem: "no column has this header, the field is not imported"}
+			if field.Required {
+				issue.Severity = IssueError
+				issue.Problem = "no column has this header and the field is required"
+			}
+			issues = append(issues, issue)
+			continue
+		}
+		used[index] = true
+		columns = append(columns, importColumn{Field: field, Index: index, Header: header[index]})
+	}
+
+	ignored := []string{}
+	for i, cell := range header {
+		if !used[i] && strings.TrimSpace(cell) != "" {
+			ignored = append(ignored, cell)
+		}
+	}
+	return columns, ignored, issues
+}
+
+// Function to validate every row of a spreadsheet against the materials stored by ID and their import keys.
+// Rows are matched and the materials no longer in the file are listed for deletion with options.Prune, nothing is written.
+func validateImport(rows [][]string, options importOptions, stored map[int]Material, keys map[int]string) ImportReport {
+	report := ImportReport{Profile: options.Profile.Name, Columns: map[string]string{}, Ignored: []string{}, Issues: []ImportIssue{}, Rows: []ImportRow{}}
+	if len(rows) == 0 {
+		report.Issues = append(report.Issues, ImportIssue{Row: 1, Severity: IssueError, Problem: "the file has no header row"})
+		report.Summary.Errors++
+		return report
+	}
+
+	columns, ignored, issues := mapImportColumns(rows[0], options.Profile)
+	report.Ignored = ignored
+	report.Issues = append(report.Issues, issues...)
+	for _, column := range columns {
+		report.Columns[column.Field.Name] = column.Header
+	}
+
+	for i, cells := range rows[1:] {
+		row, ok := parseImportRow(i+2, cells, columns)
+		if ok {
+			report.Rows = append(report.Rows, row)
+		}
+	}
+	assignRowKeys(report.Rows, columns)
+
+	matchImportRows(report.Rows, columns, options, stored, keys)
+	if options.Prune {
+		report.Rows = append(report.Rows, pruneImportRows(report.Rows, options, stored, keys)...)
+	}
+
+	report.Summary = summarizeImport(report)
+	return report
+}
+
+// Function to report whether a validated import may be written: options.Commit is set, the header row is fine
+// and no row has an error, or options.SkipInvalid leaves the invalid rows out
+func importWritable(report ImportReport, options importOptions) bool {
+	if !options.Commit || hasImportError(report.Issues) {
+		return false
+	}
+	return report.Summary.Errors == 0 || options.SkipInvalid
+}
+
+// Function to validate a spreadsheet with validateImport and write it when importWritable.
+// Reading and writing happen in one transaction.
+func runImport(ctx context.Context, db *pgxpool.Pool, rows [][]string, options importOptions) (ImportReport, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return ImportReport{}, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Other writers wait until the import is done, so the matched rows and the new IDs stay valid
+	if options.Commit {
+		if _, err := tx.Exec(ctx, "LOCK TABLE public.list_materials IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			return ImportReport{}, fmt.Errorf("unable to lock materials: %w", err)
+		}
+	}
+	stored, keys, err := selectImportedMaterials(ctx, tx)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	report := validateImport(rows, options, stored, keys)
+	if !importWritable(report, options) {
+		return report, nil
+	}
+
+	// Invalid rows are never written, applyImportRow skips them like unchanged ones
+	for _, row := range report.Rows {
+		if err := applyImportRow(ctx, tx, row, options); err != nil {
+			return report, fmt.Errorf("unable to import row %d: %w", row.Row, err)
+		}
+	}
+
+	// The run itself is recorded even when nothing changed
+	if err := insertAuditEntry(ctx, tx, importAuditEntry(report, options)); err != nil {
+		return report, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return report, fmt.Errorf("unable to commit: %w", err)
+	}
+
+	report.Committed = true
+	return report, nil
+}
+
+// Function to build the audit entry of an import run, it has no material
+func importAuditEntry(report ImportReport, options importOptions) AuditEntry {
+	return AuditEntry{
+		Actor:    options.Actor,
+		Endpoint: options.Endpoint,
+		Action:   AuditImport,
+		Changes: []FieldChange{
+			{Field: "profile", After: report.Profile},
+			{Field: "created", After: report.Summary.Created},
+			{Field: "updated", After: report.Summary.Updated},
+			{Field: "deleted", After: report.Summary.Deleted},
+			{Field: "unchanged", After: report.Summary.Unchanged},
+			{Field: "invalid", After: report.Summary.Invalid},
+			{Field: "rows", After: report.Summary.Rows},
+		},
+	}
+}
+
+// Function to parse the mapped cells of a row into a partial material. Rows whose mapped cells
+// are all blank are left out of the report.
+func parseImportRow(number int, cells []string, columns []importColumn) (ImportRow, bool) {
+	row := ImportRow{Row: number, Changes: []FieldChange{}, Issues: []ImportIssue{}, specs: map[string]string{}}
+	blank := true
+	for _, column := range columns {
+		if strings.TrimSpace(cell(cells, column.Index)) != "" {
+			blank = false
+		}
+	}
+	if blank {
+		return row, false
+	}
+
+	for _, column := range columns {
+		value := cell(cells, column.Index)
+		trimmed := strings.TrimSpace(value)
+		problem := func(severity, format string, args ...interface{}) {
+			row.Issues = append(row.Issues, ImportIssue{
+				Row: number, Column: column.Header, Field: column.Field.Name, Value: value,
+				Severity: severity, Problem: fmt.Sprintf(format, args...),
+			})
+		}
+
+		field := column.Field
+		switch {
+		case field.Required && (trimmed == "" || trimmed == placeholder):
+			problem(IssueError, "%s is required", field.Name)
+		case field.Text != nil:
+			if trimmed == placeholder && field.Name != "serial_number" {
+				problem(IssueWarning, "placeholder kept as the value")
+			}
+			*field.Text(&row.material) = trimmed
+		case trimmed == placeholder:
+			problem(IssueWarning, "placeholder read as empty")
+		case trimmed == "":
+		case field.Spec != "":
+			row.specs[field.Spec] = trimmed
+		case field.Date != nil:
+			date, err := parseImportDate(trimmed)
+			if err != nil {
+				problem(IssueError, "not a date, use M/D/YYYY, YYYY-MM-DD or an Excel date")
+				continue
+			}
+			*field.Date(&row.material) = &date
+		case field.Decimal != nil:
+			// Specifications and sizes may carry a unit, "560 kW" or "6.6kV"
+			unit := hvMotorCategory.unit(field.Name)
+			number, err := parseQuantity(trimmed, unit)
+			if err != nil {
+				problem(IssueError, "not a number%s", unitHint(unit))
+				continue
+			}
+			if number < 0 {
+				problem(IssueError, "must not be negative")
+				continue
+			}
+			*field.Decimal(&row.material) = number
+		default:
+			number, err := strconv.ParseFloat(trimmed, 64)
+			if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+				problem(IssueError, "not a number")
+				continue
+			}
+			if number < 0 {
+				problem(IssueError, "must not be negative")
+				continue
+			}
+			rounded := math.Round(number)
+			if field.Quantity != nil {
+				if rounded != number || rounded > maxStockQuantity {
+					problem(IssueError, "must be a whole number from 0 to %d", maxStockQuantity)
+					continue
+				}
+				*field.Quantity(&row.material) = int(rounded)
+				continue
+			}
+			if rounded != number {
+				problem(IssueWarning, "rounded to %d, decimals are not stored", int(rounded))
+			}
+			*field.Number(&row.material) = int(rounded)
+		}
+	}
+
+	return row, true
+}
+
+// Function to return the cell of a row, rows shorter than the header end in empty cells
+func cell(cells []string, index int) string {
+	if index < len(cells) {
+		return cells[index]
+	}
+	return ""
+}
+
+// Function to parse a date as M/D/YYYY like data.csv, YYYY-MM-DD, or the day number Excel stores
+func parseImportDate(value string) (time.Time, error) {
+	for _, layout := range []string{"1/2/2006", "2006-01-02"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+
+	// Excel counts days from 1899-12-30, 2958465 is 9999-12-31
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 || days > 2958465 {
+		return time.Time{}, errors.New("not a date")
+	}
+	return time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).AddDate(0, 0, days), nil
+}
+
+// Function to give every row its natural key, stored as import_key: "sn:" and the serial
+// number when it is unique in the file, otherwise "row:" plant, area and name, numbered when repeated.
+func assignRowKeys(rows []ImportRow, columns []importColumn) {
+	serialRows := map[string][]int{}
+	for _, row := range rows {
+		if serial := strings.TrimSpace(row.material.SerialNumber); serial != "" && serial != placeholder {
+			serialRows[serial] = append(serialRows[serial], row.Row)
+		}
+	}
+	serialHeader := ""
+	for _, column := range columns {
+		if column.Field.Name == "serial_number" {
+			serialHeader = column.Header
+		}
+	}
+
+	occurrences := map[string]int{}
+	for i := range rows {
+		row := &rows[i]
+		serial := strings.TrimSpace(row.material.SerialNumber)
+		if numbers := serialRows[serial]; len(numbers) == 1 {
+			row.Key = "sn:" + serial
+			continue
+		} else if len(numbers) > 1 {
+			others := make([]string, len(numbers))
+			for j, number := range numbers {
+				others[j] = strconv.Itoa(number)
+			}
+			row.Issues = append(row.Issues, ImportIssue{
+				Row: row.Row, Column: serialHeader, Field: "serial_number", Value: serial, Severity: IssueWarning,
+				Problem: "duplicate serial number on rows " + strings.Join(others, ", ") + ", matched by plant, area and name instead",
+			})
+		}
+
+		key := strings.Join([]string{"row", row.material.Plant, row.material.Area, row.material.Name}, ":")
+		occurrences[key]++
+		if occurrences[key] > 1 {
+			key += ":" + strconv.Itoa(occurrences[key])
+		}
+		row.Key = key
+	}
+}
+
+// Function to match every row to a stored material and work out what importing it changes.
+// Rows written before import keys existed are adopted when their ID is the row position
+// and plant and name agree, the IDs older imports of data.csv gave, their key is then set in keys.
+// A new material is of the category of its category cell, or of the profile when the cell is blank.
+func matchImportRows(rows []ImportRow, columns []importColumn, options importOptions, stored map[int]Material, keys map[int]string) {
+	byKey := map[string]int{}
+	nextID := 0
+	for id, key := range keys {
+		if key != "" {
+			byKey[key] = id
+		}
+	}
+	for id := range stored {
+		if id > nextID {
+			nextID = id
+		}
+	}
+
+	for i := range rows {
+		row := &rows[i]
+
+		id, ok := byKey[row.Key]
+		if legacy, found := stored[i+1]; !ok && found && keys[legacy.ID] == "" &&
+			legacy.Plant == row.material.Plant && legacy.Name == row.material.Name {
+			id, ok = legacy.ID, true
+			keys[legacy.ID] = row.Key
+		}
+
+		var material Material
+		if ok {
+			current := stored[id]
+			row.stored = &current
+			material = current
+		} else {
+			nextID++
+			id = nextID
+			material = Material{Category: options.Profile.Category}
+			if material.Category == "" {
+				material.Category = hvMotorCategory.Name
+			}
+		}
+		// The specs of the stored material are compared to the row, so they are copied before the row changes them
+		specs := map[string]interface{}{}
+		for name, value := range material.Specs {
+			specs[name] = value
+		}
+		material.Specs = specs
+
+		if value := strings.TrimSpace(row.material.Category); value != "" && value != placeholder {
+			material.Category = value
+			if category, ok := importCategory(value); ok {
+				material.Category = category.Name
+			}
+		}
+		category, _ := categoryByName(material.Category)
+		// Only the mapped fields are taken from the row, the others keep their stored value
+		for _, column := range columns {
+			field := column.Field
+			switch {
+			case field.Check || field.Name == "category":
+			case field.Spec != "":
+				overlayImportSpec(row, &material, category, column)
+			case (field.Decimal != nil || field.Number != nil) && specAttributeOf(category, field.Name):
+				// Another category keeps its capacity, voltage, current, rpm and frame in specs rather than in the HV Motor columns
+				var value float64
+				if field.Decimal != nil {
+					value = *field.Decimal(&row.material)
+				} else {
+					value = float64(*field.Number(&row.material))
+				}
+				delete(material.Specs, field.Name)
+				if value != 0 {
+					material.Specs[field.Name] = value
+				}
+			default:
+				overlayImportField(&material, row.material, field)
+			}
+		}
+		if material.StartingCurrent.Frequency == "" {
+			material.StartingCurrent.Frequency = frequencyOfWhen(material.StartingCurrent.When)
+		}
+		material.ID = id
+		row.check = importRotorBarCheck(row, &material, columns, options.Actor)
+		row.material = material
+		row.MaterialID = id
+
+		var problems InputErrors
+		if err := validateMaterial(material); errors.As(err, &problems) {
+			headers := map[string]string{}
+			for _, column := range columns {
+				headers[column.Field.Name] = column.Header
+			}
+			for _, problem := range problems {
+				// Material fields are nested, e.g. size.c, import fields are not but for specs.<name>
+				field := problem.Field
+				if _, ok := headers[field]; !ok {
+					field = field[strings.LastIndex(field, ".")+1:]
+				}
+				row.Issues = append(row.Issues, ImportIssue{
+					Row: row.Row, Column: headers[field], Field: field, Value: problem.Value,
+					Severity: IssueError, Problem: problem.Reason,
+				})
+			}
+		}
+		if options.Allowed != nil {
+			plants := []string{material.Plant}
+			if row.stored != nil && row.stored.Plant != material.Plant {
+				plants = append(plants, row.stored.Plant)
+			}
+			for _, plant := range plants {
+				if !options.Allowed(plant) {
+					row.Issues = append(row.Issues, ImportIssue{
+						Row: row.Row, Field: "plant", Value: plant, Severity: IssueError,
+						Problem: fmt.Sprintf("%s on plant %s is required", RolePlanner, plant),
+					})
+				}
+			}
+		}
+
+		row.Changes = diffMaterials(row.stored, &material)
+		if row.stored != nil && keys[id] != row.Key {
+			row.Changes = append(row.Changes, FieldChange{Field: "import_key", Before: keys[id], After: row.Key})
+		}
+		switch {
+		case hasImportError(row.Issues):
+			row.Action = ImportInvalid
+		case row.stored == nil:
+			row.Action = AuditCreate
+		case len(row.Changes) > 0:
+			row.Action = AuditUpdate
+		default:
+			row.Action = ImportUnchanged
+		}
+	}
+}
+
+// Function to turn the rotor bar columns of a row into a check of material, nil when the row holds no check newer
+// than the status material derives. The status of material is set to the check, so the diff of the row shows it.
+// A status without a check date cannot be ordered against the stored checks, it is reported and left out.
+func importRotorBarCheck(row *ImportRow, material *Material, columns []importColumn, actor string) *RotorBarCheck {
+	headers := map[string]string{}
+	for _, column := range columns {
+		if column.Field.Check {
+			headers[column.Field.Name] = column.Header
+		}
+	}
+	imported := row.material.RotorBar
+	status := strings.ToUpper(strings.TrimSpace(imported.CheckStatus))
+	if status == placeholder {
+		status = ""
+	}
+	if imported.CheckDate == nil && status == "" {
+		return nil
+	}
+	if imported.CheckDate == nil || status == "" {
+		field, value := "rotor_bar_check_date", ""
+		if status == "" {
+			field, value = "rotor_bar_check_status", imported.CheckDate.Format("2006-01-02")
+		}
+		row.Issues = append(row.Issues, ImportIssue{
+			Row: row.Row, Column: headers[field], Field: field, Value: value, Severity: IssueWarning,
+			Problem: "a rotor bar check needs both a check date and a check status, it is not recorded",
+		})
+		return nil
+	}
+
+	current := material.RotorBar
+	if current.CheckDate != nil {
+		if imported.CheckDate.Before(*current.CheckDate) {
+			return nil
+		}
+		if imported.CheckDate.Equal(*current.CheckDate) && current.CheckStatus == status &&
+			current.Reason == imported.Reason && current.Remark == imported.Remark {
+			return nil
+		}
+	}
+
+	date := *imported.CheckDate
+	material.RotorBar.CheckDate, material.RotorBar.CheckStatus = &date, status
+	material.RotorBar.Reason, material.RotorBar.Remark = imported.Reason, imported.Remark
+	return &RotorBarCheck{
+		MaterialID:  material.ID,
+		CheckDate:   date.Format("2006-01-02"),
+		Status:      status,
+		Inspector:   actor,
+		Reason:      imported.Reason,
+		Findings:    imported.Remark,
+		Attachments: []string{},
+	}
+}
+
+// Function to list the imported materials no row of the file matched, as rows without a number that delete them.
+// Materials without an import key were never imported and are left alone.
+func pruneImportRows(rows []ImportRow, options importOptions, stored map[int]Material, keys map[int]string) []ImportRow {
+	matched := map[int]bool{}
+	for _, row := range rows {
+		matched[row.MaterialID] = true
+	}
+
+	ids := []int{}
+	for id, key := range keys {
+		if key != "" && !matched[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	pruned := make([]ImportRow, len(ids))
+	for i, id := range ids {
+		material := stored[id]
+		row := ImportRow{Key: keys[id], MaterialID: id, Action: AuditDelete, Changes: diffMaterials(&material, nil), Issues: []ImportIssue{}, stored: &material}
+		if options.Allowed != nil && !options.Allowed(material.Plant) {
+			row.Action = ImportInvalid
+			row.Issues = append(row.Issues, ImportIssue{
+				Field: "plant", Value: material.Plant, Severity: IssueError,
+				Problem: fmt.Sprintf("%s on plant %s is required", RolePlanner, material.Plant),
+			})
+		}
+		pruned[i] = row
+	}
+	return pruned
+}
+
+// Function to report whether category stores the attribute name in specs
+func specAttributeOf(category Category, name string) bool {
+	for _, attribute := range category.Attributes {
+		if attribute.Name == name {
+			return attribute.inSpecs()
+		}
+	}
+	return false
+}
+
+// Function to copy the cell of a specs.<name> column onto the specs of material, parsed as the type the
+// category declares. A blank cell removes the attribute, a cell of an attribute the category lacks is an error.
+func overlayImportSpec(row *ImportRow, material *Material, category Category, column importColumn) {
+	name := column.Field.Spec
+	value, ok := row.specs[name]
+	delete(material.Specs, name)
+	if !ok {
+		return
+	}
+	problem := func(format string, args ...interface{}) {
+		row.Issues = append(row.Issues, ImportIssue{
+			Row: row.Row, Column: column.Header, Field: column.Field.Name, Value: value,
+			Severity: IssueError, Problem: fmt.Sprintf(format, args...),
+		})
+	}
+
+	for _, attribute := range category.Attributes {
+		if attribute.Name != name || !attribute.inSpecs() {
+			continue
+		}
+		if attribute.Type == AttributeString {
+			material.Specs[name] = value
+			return
+		}
+		number, err := parseQuantity(value, attribute.Unit)
+		if err != nil {
+			problem("not a number%s", unitHint(attribute.Unit))
+			return
+		}
+		if number < 0 {
+			problem("must not be negative")
+			return
+		}
+		material.Specs[name] = number
+		return
+	}
+	problem("is not an attribute of %s", material.Category)
+}
+
+// Function to copy one field from a parsed row onto a material
+func overlayImportField(material *Material, row Material, field importField) {
+	switch {
+	case field.Text != nil:
+		*field.Text(material) = *field.Text(&row)
+	case field.Number != nil:
+		*field.Number(material) = *field.Number(&row)
+	case field.Decimal != nil:
+		*field.Decimal(material) = *field.Decimal(&row)
+	case field.Quantity != nil:
+		*field.Quantity(material) = *field.Quantity(&row)
+	case field.Date != nil:
+		*field.Date(material) = *field.Date(&row)
+	}
+}
+
+// Function to map the "When ?" column of data.csv to a check frequency, like the schema backfill
+// and with MONTHLY or EVERY MONTH for motors checked on a calendar instead of on starts
+func frequencyOfWhen(when string) string {
+	switch strings.ToUpper(strings.TrimSpace(when)) {
+	case "EVERY TIME":
+		return FrequencyEveryStart
+	case "NOT EVERY TIME":
+		return FrequencyOverhaul
+	case "MONTHLY", "EVERY MONTH":
+		return FrequencyMonthly
+	default:
+		return ""
+	}
+}
+
+// Function to select every material by ID along with the import key of each ID, "" when it has none
+func selectImportedMaterials(ctx context.Context, tx pgx.Tx) (map[int]Material, map[int]string, error) {
+	rows, err := tx.Query(ctx, "SELECT "+materialColumns+" FROM public.list_materials")
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	materials := map[int]Material{}
+	for rows.Next() {
+		material, err := scanMaterial(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+		materials[material.ID] = material
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	rows, err = tx.Query(ctx, "SELECT id, COALESCE(import_key, '') FROM public.list_materials")
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	keys := map[int]string{}
+	for rows.Next() {
+		var id int
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			return nil, nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+		keys[id] = key
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	return materials, keys, nil
+}
+
+func hasImportError(issues []ImportIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == IssueError {
+			return true
+		}
+	}
+	return false
+}
+
+func summarizeImport(report ImportReport) ImportSummary {
+	var summary ImportSummary
+	issues := report.Issues
+	for _, row := range report.Rows {
+		issues = append(issues, row.Issues...)
+		// Pruned materials have no row in the file
+		if row.Row > 0 {
+			summary.Rows++
+		}
+		switch row.Action {
+		case AuditCreate:
+			summary.Created++
+		case AuditUpdate:
+			summary.Updated++
+		case AuditDelete:
+			summary.Deleted++
+		case ImportUnchanged:
+			summary.Unchanged++
+		case ImportInvalid:
+			summary.Invalid++
+		}
+	}
+	for _, issue := range issues {
+		if issue.Severity == IssueError {
+			summary.Errors++
+		} else {
+			summary.Warnings++
+		}
+	}
+	return summary
+}
+
+// Function to write one validated row with its import key, stock adjustments, rotor bar check and audit entry
+func applyImportRow(ctx context.Context, tx pgx.Tx, row ImportRow, options importOptions) error {
+	var err error
+	switch row.Action {
+	case AuditCreate:
+		if _, err = insertMaterial(ctx, tx, row.material); err == nil {
+			err = insertOpeningBalances(ctx, tx, row.material, options.Actor)
+		}
+	case AuditUpdate:
+		if _, err = updateMaterial(ctx, tx, row.material); err == nil {
+			err = insertStockAdjustments(ctx, tx, *row.stored, row.material, options.Actor, "Spreadsheet import")
+		}
+	case AuditDelete:
+		err = deleteMaterialByID(ctx, tx, row.MaterialID)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	id := row.MaterialID
+	if row.Action == AuditDelete {
+		return insertAuditEntry(ctx, tx, AuditEntry{Actor: options.Actor, Endpoint: options.Endpoint, Action: row.Action, MaterialID: &id, Changes: row.Changes})
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE public.list_materials SET import_key = $2 WHERE id = $1", row.MaterialID, row.Key); err != nil {
+		return fmt.Errorf("unable to set import key: %w", err)
+	}
+	if row.check != nil {
+		if _, err := insertRotorBarCheck(ctx, tx, *row.check); err != nil {
+			return err
+		}
+	}
+
+	return insertAuditEntry(ctx, tx, AuditEntry{
+		Actor:      options.Actor,
+		Endpoint:   options.Endpoint,
+		Action:     row.Action,
+		MaterialID: &id,
+		Changes:    row.Changes,
+	})
+}
+
+// Function to print a report as text, one line per issue and changed row followed by the summary
+func printImportReport(w io.Writer, report ImportReport) {
+	for _, issue := range report.Issues {
+		fmt.Fprintf(w, "row %-5d %-8s %s %q: %s\n", issue.Row, issue.Severity, issue.Field, issue.Value, issue.Problem)
+	}
+	for _, row := range report.Rows {
+		for _, issue := range row.Issues {
+			fmt.Fprintf(w, "row %-5d %-8s %s %q: %s\n", issue.Row, issue.Severity, issue.Field, issue.Value, issue.Problem)
+		}
+		if row.Action == AuditCreate || row.Action == AuditUpdate || row.Action == AuditDelete {
+			fields := make([]string, len(row.Changes))
+			for i, change := range row.Changes {
+				fields[i] = change.Field
+			}
+			fmt.Fprintf(w, "row %-5d %-8s %s id=%d %s\n", row.Row, row.Action, row.Key, row.MaterialID, strings.Join(fields, ", "))
+		}
+	}
+
+	summary := report.Summary
+	fmt.Fprintf(w, "Summary: %d rows, %d create, %d update, %d delete, %d unchanged, %d invalid, %d errors, %d warnings\n",
+		summary.Rows, summary.Created, summary.Updated, summary.Deleted, summary.Unchanged, summary.Invalid, summary.Errors, summary.Warnings)
+}
+
+// Function to run the import command, which validates a spreadsheet and writes it with -commit.
+// It runs with the rights of the database account, like create-user.
+func runImportCommand(ctx context.Context, imports ImportRepository, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := flags.String("file", "", "CSV or XLSX file to import")
+	profileName := flags.String("profile", defaultProfile, "mapping profile of the file's columns")
+	commit := flags.Bool("commit", false, "write the file when it has no errors, otherwise only report")
+	prune := flags.Bool("prune", false, "delete imported materials that are no longer in the file")
+	skipInvalid := flags.Bool("skip-invalid", false, "with -commit, write the valid rows and skip the invalid ones")
+	actor := flags.String("actor", os.Getenv("USER"), "name recorded as the actor in the audit log")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" || strings.TrimSpace(*actor) == "" {
+		return errors.New("import: -file and -actor are required")
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	rows, err := readSpreadsheet(data)
+	if err != nil {
+		return err
+	}
+	profile, err := imports.Profile(ctx, *profileName)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *profileName, err)
+	}
+
+	report, err := imports.Run(ctx, rows, importOptions{
+		Profile:     profile,
+		Commit:      *commit,
+		Prune:       *prune,
+		SkipInvalid: *skipInvalid,
+		Actor:       *actor,
+		Endpoint:    "import " + *file,
+	})
+	if err != nil {
+		return err
+	}
+	printImportReport(os.Stdout, report)
+
+	switch {
+	case report.Committed && report.Summary.Invalid > 0:
+		fmt.Printf("Committed, %d invalid rows were skipped\n", report.Summary.Invalid)
+	case report.Committed:
+		fmt.Println("Committed")
+	case *commit:
+		return fmt.Errorf("import: %d errors, nothing was written, fix them or run with -skip-invalid", report.Summary.Errors)
+	default:
+		fmt.Println("Dry run, nothing was written, run with -commit to write")
+	}
+	return nil
+}
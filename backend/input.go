@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InputError is one invalid value of a request, Field is the query parameter or JSON field it came from
+type InputError struct {
+	Field  string `json:"field"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+func (e InputError) Error() string {
+	if e.Field == "" {
+		return e.Reason
+	}
+	return e.Field + " " + e.Reason
+}
+
+// InputErrors are every invalid value of a request, reported together
+type InputErrors []InputError
+
+func (errs InputErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, ", ")
+}
+
+// ErrorResponse is the body of a 400, listing what is wrong with the request
+type ErrorResponse struct {
+	Response struct {
+		Success bool         `json:"success"`
+		Errors  []InputError `json:"errors"`
+	} `json:"response"`
+}
+
+// Function to write err as a 400 error envelope, an error other than InputError or InputErrors becomes its reason
+func writeInputError(w http.ResponseWriter, err error) {
+	var errs InputErrors
+	var single InputError
+	switch {
+	case errors.As(err, &errs):
+	case errors.As(err, &single):
+		errs = InputErrors{single}
+	default:
+		errs = InputErrors{{Reason: err.Error()}}
+	}
+
+	var response ErrorResponse
+	response.Response.Success = false
+	response.Response.Errors = errs
+
+	writeJSON(w, http.StatusBadRequest, response)
+}
+
+// Function to decode a JSON request body into v. Unknown fields, values of the wrong type
+// and trailing data are rejected as InputErrors.
+func decodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err == nil && decoder.More() {
+		return InputError{Reason: "the body must contain a single JSON value"}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, io.EOF):
+		return InputError{Reason: "the body is empty"}
+	case errors.As(err, &typeErr):
+		return InputError{Field: typeErr.Field, Value: typeErr.Value, Reason: "must be " + jsonKind(typeErr.Type)}
+	case errors.As(err, &syntaxErr):
+		return InputError{Reason: fmt.Sprintf("the body is not valid JSON at offset %d", syntaxErr.Offset)}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return InputError{Field: field, Reason: "is not a known field"}
+	default:
+		return InputError{Reason: err.Error()}
+	}
+}
+
+// Function to name the JSON kind a Go type is decoded from
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// queryParser reads query parameters strictly. A missing parameter reads as its zero value,
+// an invalid one is kept as an InputError and reported by valid.
+type queryParser struct {
+	query  url.Values
+	errors InputErrors
+}
+
+func newQueryParser(r *http.Request) *queryParser {
+	return &queryParser{query: r.URL.Query()}
+}
+
+// Function to record an invalid parameter
+func (p *queryParser) fail(name, value, reason string) {
+	p.errors = append(p.errors, InputError{Field: name, Value: value, Reason: reason})
+}
+
+// Function to report whether every parameter read so far is valid, writing a 400 when not
+func (p *queryParser) valid(w http.ResponseWriter) bool {
+	if len(p.errors) == 0 {
+		return true
+	}
+	writeInputError(w, p.errors)
+	return false
+}
+
+// Function to read a number, 0 when missing
+func (p *queryParser) float(name string) float64 {
+	value := strings.TrimSpace(p.query.Get(name))
+	if value == "" {
+		return 0
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		p.fail(name, value, "must be a number")
+		return 0
+	}
+	return number
+}
+
+// Function to read a whole number of at least 0, 0 when missing
+func (p *queryParser) count(name string) int {
+	value := strings.TrimSpace(p.query.Get(name))
+	if value == "" {
+		return 0
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		p.fail(name, value, "must be a whole number of at least 0")
+		return 0
+	}
+	return number
+}
+
+// Function to read true or false, false when missing
+func (p *queryParser) bool(name string) bool {
+	value := strings.TrimSpace(p.query.Get(name))
+	if value == "" {
+		return false
+	}
+
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(name, value, "must be true or false")
+		return false
+	}
+	return flag
+}
+
+// Function to read a date in one of layouts, the zero time when missing
+func (p *queryParser) date(name, reason string, layouts ...string) time.Time {
+	value := strings.TrimSpace(p.query.Get(name))
+	if value == "" {
+		return time.Time{}
+	}
+
+	for _, layout := range layouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date
+		}
+	}
+	p.fail(name, value, reason)
+	return time.Time{}
+}
+
+// Function to read a free-text search, "" when missing
+func (p *queryParser) search(name string) string {
+	value := strings.TrimSpace(p.query.Get(name))
+	if value != "" && searchTSQuery(value) == "" {
+		p.fail(name, value, "must contain a letter or digit")
+		return ""
+	}
+	return value
+}
+
+// Function to read limit and offset, a limit of 0 means no limit
+func (p *queryParser) pagination() (limit, offset int) {
+	return p.count("limit"), p.count("offset")
+}
+
+// Function to read a tolerance such as "5", "0.5kV" or "2%", converted to unit unless it is a percentage, 0 when missing
+func (p *queryParser) tolerance(name, unit string) (tolerance float64, percent bool) {
+	value := strings.TrimSpace(p.query.Get(name))
+	if value == "" {
+		return 0, false
+	}
+
+	var err error
+	if strings.HasSuffix(value, "%") {
+		percent = true
+		tolerance, err = strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, "%")), 64)
+	} else {
+		tolerance, err = parseQuantity(value, unit)
+	}
+	if err != nil || math.IsNaN(tolerance) || math.IsInf(tolerance, 0) || tolerance < 0 {
+		p.fail(name, value, "must be a number of at least 0"+unitHint(unit)+", or a percentage such as 2%")
+		return 0, false
+	}
+	return tolerance, percent
+}
+
+// Function to read a number given in unit or in another unit of the same quantity, nil when missing
+func (p *queryParser) quantity(name, unit string) *float64 {
+	value := strings.TrimSpace(p.query.Get(name))
+	if value == "" {
+		return nil
+	}
+
+	number, err := parseQuantity(value, unit)
+	if err != nil {
+		p.fail(name, value, err.Error())
+		return nil
+	}
+	return &number
+}
+
+// Function to read the value, min_, max_ and _tol parameters of a numeric filter, converted to unit.
+// The tolerance is absolute (shaft_diameter_tol=5) or relative with a % suffix (rpm_tol=2%).
+func (p *queryParser) numericFilter(name, unit string) NumericFilter {
+	filter := NumericFilter{
+		Value: p.quantity(name, unit),
+		Min:   p.quantity("min_"+name, unit),
+		Max:   p.quantity("max_"+name, unit),
+	}
+	filter.Tolerance, filter.TolPercent = p.tolerance(name+"_tol", unit)
+
+	// A tolerance widens the value, on its own it would be silently ignored
+	if tolerance := strings.TrimSpace(p.query.Get(name + "_tol")); tolerance != "" && strings.TrimSpace(p.query.Get(name)) == "" {
+		p.fail(name+"_tol", tolerance, "requires "+name)
+	}
+	if filter.Min != nil && filter.Max != nil && *filter.Min > *filter.Max {
+		p.fail("min_"+name, p.query.Get("min_"+name), "must not be greater than max_"+name)
+	}
+	return filter
+}
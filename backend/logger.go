@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Log levels in increasing severity, matching the LOG_LEVEL values
+const (
+	levelDebug = "debug"
+	levelInfo  = "info"
+	levelWarn  = "warn"
+	levelError = "error"
+)
+
+var levelRanks = map[string]int{levelDebug: 0, levelInfo: 1, levelWarn: 2, levelError: 3}
+
+// logLevel is the minimum level written, set from the config at startup
+var logLevel = levelInfo
+
+// logMutex keeps the lines of concurrent requests from interleaving
+var logMutex sync.Mutex
+
+// Function to log a message when level is at or above logLevel
+func logf(level, format string, args ...interface{}) {
+	logEvent(level, fmt.Sprintf(format, args...), nil)
+}
+
+// Function to log a message and its fields as one JSON object per line on stderr, when level is at or above logLevel
+func logEvent(level, message string, fields map[string]interface{}) {
+	if levelRanks[level] < levelRanks[logLevel] {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(fields)+3)
+	for key, value := range fields {
+		entry[key] = value
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = message
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"time": entry["time"].(string), "level": level, "msg": message, "error": err.Error()})
+	}
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	os.Stderr.Write(append(line, '\n'))
+}
+
+// Function to log an error and exit, for startup failures
+func fatalf(format string, args ...interface{}) {
+	logf(levelError, format, args...)
+	os.Exit(1)
+}
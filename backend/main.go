@@ -3,22 +3,45 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool" // Correct import path for v5
+	"github.com/rs/cors"
 )
 
 var db *pgxpool.Pool
 
-var connString = fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=disable&pool_max_conns=10", "postgres", "eicdev", "localhost", "15432", "electra")
+// querier is implemented by both the pool and a transaction, so queries can run inside either
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// materialsPath is the base route of the materials of every category
+const materialsPath = "/api/v1/intools/electra/materials"
+
+// hvMotorPath is the base route of the HV motor materials
+const hvMotorPath = materialsPath + "/motor/high-voltage"
+
+// reportsPath is the base route of the reports
+const reportsPath = "/api/v1/intools/electra/reports"
+
+// materialColumns is the column list every material query selects, in scanMaterial order
+const materialColumns = "plant, area, category, name, capacity, voltage, current, rpm, shaft_diameter, base_width, base_length, c, e, h, maker, id, qcode, frame, installed_qty, standby_qty, spare_qty, serial_number, type, starting_current_when, starting_current_check, starting_current_frequency, starting_current_last_check, starting_current_last_start, starting_current_last_overhaul, rotor_bar_check_date, rotor_bar_check_status, rotor_bar_reason, rotor_bar_remark, operation, remark, specs, pic_team, pic_name, pic_phone, pic_email, created_at, updated_at"
 
+// Material is one item of list_materials, Specifications and Size are decimals in the units listed in Units
 type Material struct {
 	ID             int    `json:"id"`
 	QCode          string `json:"qcode"`
@@ -27,31 +50,58 @@ type Material struct {
 	Category       string `json:"category"`
 	Name           string `json:"name"`
 	Specifications struct {
-		Capacity int `json:"capacity"`
-		Voltage  int `json:"voltage"`
-		Current  int `json:"current"`
-		RPM      int `json:"rpm"`
+		Capacity float64 `json:"capacity"`
+		Voltage  float64 `json:"voltage"`
+		Current  float64 `json:"current"`
+		RPM      float64 `json:"rpm"`
 	} `json:"specifications"`
 	Size struct {
-		ShaftDiameter int `json:"shaft_diameter"`
-		BaseWidth     int `json:"base_width"`
-		BaseLength    int `json:"base_length"`
-		C             int `json:"c"`
-		E             int `json:"e"`
-		H             int `json:"h"`
+		ShaftDiameter float64 `json:"shaft_diameter"`
+		BaseWidth     float64 `json:"base_width"`
+		BaseLength    float64 `json:"base_length"`
+		C             float64 `json:"c"`
+		E             float64 `json:"e"`
+		H             float64 `json:"h"`
 	} `json:"size"`
-	Maker     string `json:"maker"`
-	Frame     int    `json:"frame"`
-	Type      string `json:"type"`
-	Installed int8   `json:"installed_qty"`
-	StandBy   int8   `json:"standby_qty"`
-	Spare     int8   `json:"spare_qty"`
-	PIC       struct {
+	Maker        string `json:"maker"`
+	SerialNumber string `json:"serial_number"`
+	Frame        int    `json:"frame"`
+	Type         string `json:"type"`
+	// Installed, StandBy and Spare are balances derived from the stock movements of the material
+	Installed       int `json:"installed_qty"`
+	StandBy         int `json:"standby_qty"`
+	Spare           int `json:"spare_qty"`
+	StartingCurrent struct {
+		When         string     `json:"when"`
+		Check        string     `json:"check"`
+		Frequency    string     `json:"frequency"`
+		LastCheck    *time.Time `json:"last_check"`
+		LastStart    *time.Time `json:"last_start"`
+		LastOverhaul *time.Time `json:"last_overhaul"`
+	} `json:"starting_current"`
+	// RotorBar mirrors the latest rotor bar check of the material and is empty without one
+	RotorBar struct {
+		CheckDate   *time.Time `json:"check_date"`
+		CheckStatus string     `json:"check_status"`
+		Reason      string     `json:"reason"`
+		Remark      string     `json:"remark"`
+	} `json:"rotor_bar"`
+	Operation string `json:"operation"`
+	Remark    string `json:"remark"`
+	// Specs holds the attributes of categories other than HV Motor, see categories
+	Specs map[string]interface{} `json:"specs"`
+	// Units maps each attribute with a unit to it, e.g. capacity to kW, it is derived from the category
+	Units map[string]string `json:"units"`
+	PIC   struct {
 		Team  string `json:"team"`
 		Name  string `json:"name"`
 		Phone string `json:"phone"`
 		Email string `json:"email"`
 	} `json:"pic"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Match is set when the material was found by q=
+	Match *SearchMatch `json:"match,omitempty"`
 }
 
 type APIResponse struct {
@@ -61,47 +111,198 @@ type APIResponse struct {
 	} `json:"request"`
 	Response struct {
 		Count   int        `json:"count"`
+		Total   int        `json:"total"`
+		Next    *string    `json:"next"`
+		Prev    *string    `json:"prev"`
 		Success bool       `json:"success"`
 		Data    []Material `json:"data"`
+		// Facets is only set when ?facets= asks for it
+		Facets map[string][]FacetValue `json:"facets,omitempty"`
 	} `json:"response"`
 }
 
 // QueryParams represents the query parameters
 type QueryParams struct {
-	Frame         int `json:"frame"`
-	Capacity      int `json:"capacity"`
-	Voltage       int `json:"voltage"`
-	Current       int `json:"current"`
-	RPM           int `json:"rpm"`
-	ShaftDiameter int `json:"shaft_diameter"`
-	BaseWidth     int `json:"base_width"`
-	BaseLength    int `json:"base_length"`
-	C             int `json:"c"`
-	E             int `json:"e"`
-	H             int `json:"h"`
+	Frame         NumericFilter `json:"frame"`
+	Capacity      NumericFilter `json:"capacity"`
+	Voltage       NumericFilter `json:"voltage"`
+	Current       NumericFilter `json:"current"`
+	RPM           NumericFilter `json:"rpm"`
+	ShaftDiameter NumericFilter `json:"shaft_diameter"`
+	BaseWidth     NumericFilter `json:"base_width"`
+	BaseLength    NumericFilter `json:"base_length"`
+	C             NumericFilter `json:"c"`
+	E             NumericFilter `json:"e"`
+	H             NumericFilter `json:"h"`
+	// Category limits the results to one category name, "" matches every category
+	Category string `json:"category"`
+	// Search is the q= text, matched against names, serial numbers, makers and remarks and ranked, "" matches everything
+	Search string `json:"q"`
+	// Sort orders the matches, by id or by rank when searching if empty
+	Sort []SortKey `json:"sort"`
+	// Facets are the columns to count the values of among the matches, they do not filter
+	Facets []string `json:"facets"`
+	// Attributes and Texts filter on the attributes a category declares
+	Attributes []numericColumn `json:"-"`
+	Texts      []textColumn    `json:"-"`
+}
+
+// NumericFilter represents the filter on one numeric column, nil values mean "not set" so 0 can be filtered on.
+// Value matches exactly unless a Tolerance is given, Min and Max are inclusive bounds.
+type NumericFilter struct {
+	Value      *float64 `json:"value"`
+	Min        *float64 `json:"min"`
+	Max        *float64 `json:"max"`
+	Tolerance  float64  `json:"tolerance"`
+	TolPercent bool     `json:"tolerance_percent"`
+}
+
+// numericColumn ties a NumericFilter to the column it filters
+type numericColumn struct {
+	// Column is a column name or SQL expression, never user input
+	Column string
+	// Name is the attribute filtered, empty when Column is a column name
+	Name   string
+	Filter NumericFilter
+	// AtLeast treats Value as a lower bound only, e.g. a bigger capacity is still a match
+	AtLeast bool
+}
+
+// numericColumns lists every numeric filter in the order it is added to the WHERE clause
+func (params QueryParams) numericColumns() []numericColumn {
+	return append([]numericColumn{
+		{Column: "capacity", Filter: params.Capacity, AtLeast: true},
+		{Column: "frame", Filter: params.Frame},
+		{Column: "voltage", Filter: params.Voltage},
+		{Column: "current", Filter: params.Current},
+		{Column: "rpm", Filter: params.RPM},
+		{Column: "shaft_diameter", Filter: params.ShaftDiameter},
+		{Column: "base_width", Filter: params.BaseWidth},
+		{Column: "base_length", Filter: params.BaseLength},
+		{Column: "c", Filter: params.C},
+		{Column: "e", Filter: params.E},
+		{Column: "h", Filter: params.H},
+	}, params.Attributes...)
+}
+
+// textColumn is an equality filter on a string attribute
+type textColumn struct {
+	Column string
+	Name   string
+	Value  string
+}
+
+// Bounds returns the inclusive range described by the filter, nil means unbounded
+func (f NumericFilter) Bounds(atLeast bool) (lower, upper *float64) {
+	bound := func(v float64) *float64 { return &v }
+
+	if f.Value != nil {
+		tolerance := f.Tolerance
+		if f.TolPercent {
+			tolerance = math.Abs(*f.Value) * f.Tolerance / 100
+		}
+		lower = bound(*f.Value - tolerance)
+		if !atLeast {
+			upper = bound(*f.Value + tolerance)
+		}
+	}
+
+	// Explicit min_/max_ bounds narrow the range further
+	if f.Min != nil && (lower == nil || *f.Min > *lower) {
+		lower = bound(*f.Min)
+	}
+	if f.Max != nil && (upper == nil || *f.Max < *upper) {
+		upper = bound(*f.Max)
+	}
+
+	return lower, upper
 }
 
 func main() {
-	var err error
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "KEY=VALUE config file, environment variables take precedence (default .env when present)")
+	flag.Parse()
+
+	// Load and validate the config before touching the database
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	logLevel = cfg.LogLevel
+	authSecret = []byte(cfg.AuthTokenSecret)
+	authTokenTTL = cfg.AuthTokenTTL
 
 	// Create a connection pool
-	config, err := pgxpool.ParseConfig(connString)
+	config, err := cfg.poolConfig()
 	if err != nil {
-		log.Fatal("Error parsing connection string:", err)
+		fatalf("Error parsing connection string: %v", err)
 	}
 
-	db, err = pgxpool.NewWithConfig(context.Background(), config)
+	// Wait for the database instead of serving 500s while it starts
+	db, err = connectDatabase(context.Background(), config, cfg.DBConnectTimeout)
 	if err != nil {
-		log.Fatal("Unable to connect to the database:", err)
+		fatalf("Unable to connect to the database: %v", err)
 	}
 	defer db.Close()
+	repo := newPgMaterialRepository(db)
+	materialRepo, recordRepo = repo, repo
+	userRepo, importRepo = newPgUserRepository(db), newPgImportRepository(db)
+
+	// The migrate command manages the schema itself instead of serving
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrateCommand(context.Background(), db, flag.Args()[1:]); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
 
-	http.HandleFunc("/api/v1/intools/electra/materials/motor/high-voltage-all", getMaterials)
-	http.HandleFunc("/api/v1/intools/electra/materials/motor/high-voltage", getMaterialsByParams)
+	// Bring the schema up to date when configured, otherwise only warn that it is behind
+	if cfg.MigrateOnStart {
+		applied, err := migrateUp(context.Background(), db, 0)
+		if err != nil {
+			fatalf("Unable to migrate the database schema: %v", err)
+		}
+		for _, migration := range applied {
+			logf(levelInfo, "Applied migration %04d %s", migration.Version, migration.Name)
+		}
+	} else if pending, err := pendingMigrations(context.Background(), db); err != nil {
+		fatalf("Unable to read the database schema version: %v", err)
+	} else if pending > 0 {
+		logf(levelWarn, "%d migrations are pending, run migrate up or set MIGRATE_ON_START=true", pending)
+	}
+
+	// The create-user command bootstraps accounts instead of serving
+	if flag.Arg(0) == "create-user" {
+		if err := runCreateUser(context.Background(), userRepo, flag.Args()[1:]); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	// The import command validates, and with -commit writes, a spreadsheet instead of serving
+	if flag.Arg(0) == "import" {
+		if err := runImportCommand(context.Background(), importRepo, flag.Args()[1:]); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	registerRoutes(http.DefaultServeMux)
+
+	// Wrap the default ServeMux so preflight requests never reach the handlers.
+	// Tokens travel in the Authorization header, so no origin needs credentials.
+	corsHandler := cors.New(cors.Options{
+		AllowedOrigins:   cfg.CORSOrigins,
+		AllowCredentials: false,
+		AllowedHeaders:   []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "Accept", "Origin", "Cache-Control", "X-Requested-With"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
+	})
 
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: nil, // Use the default ServeMux
+		Addr:         cfg.ListenAddr,
+		Handler:      observeRequests(http.DefaultServeMux, corsHandler.Handler(http.DefaultServeMux)),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
 
 	// Use a goroutine to handle server shutdown
@@ -112,268 +313,261 @@ func main() {
 
 		// Wait for the signal to stop the server
 		<-stop
-		fmt.Println("\nServer is shutting down...")
+		logf(levelInfo, "Server is shutting down")
 
 		// Create a context with a timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 		defer cancel()
 
 		// Shutdown the server
 		if err := server.Shutdown(ctx); err != nil {
-			log.Fatal("Server shutdown error:", err)
+			fatalf("Server shutdown error: %v", err)
 		}
 	}()
 
 	// Print a message indicating that the server is starting
-	fmt.Println("Server is starting and listening on :8080")
+	logf(levelInfo, "Server is starting and listening on %s", cfg.ListenAddr)
 
 	// Start the server
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
-		log.Fatal("Server error:", err)
+		fatalf("Server error: %v", err)
 	}
 }
 
-func getMaterials(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers to the response
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Credentials", "true")
-	w.Header().Add("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-	w.Header().Add("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// Function to register every route on mux, the handlers reach the data through the repositories set in main
+func registerRoutes(mux *http.ServeMux) {
+	// Everything but login, the metrics and the health probes requires a session token
+	mux.HandleFunc(authPath+"/login", login)
+	mux.HandleFunc(authPath+"/me", requireAuth(getCurrentUser))
+	mux.HandleFunc(authPath+"/users", requireAuth(userRoutes))
+	mux.HandleFunc(authPath+"/users/", requireAuth(userRoutes))
+	mux.HandleFunc(hvMotorPath+"-all", requireAuth(getMaterials))
+	mux.HandleFunc(hvMotorPath, requireAuth(getMaterialsByParams))
+	mux.HandleFunc(hvMotorPath+"/", requireAuth(materialRoutes))
+	mux.HandleFunc(materialsPath+"/", requireAuth(categoryRoutes))
+	mux.HandleFunc(reportsPath+"/starting-current-overdue", requireAuth(getStartingCurrentOverdue))
+	mux.HandleFunc(reportsPath+"/spare-coverage", requireAuth(getSpareCoverage))
+	mux.HandleFunc(auditPath, requireAuth(getAudit))
+	mux.HandleFunc(importsPath, requireAuth(importRoutes))
+	mux.HandleFunc(importsPath+"/", requireAuth(importRoutes))
+	mux.HandleFunc(metricsPath, getMetrics)
+	mux.HandleFunc(healthzPath, getHealthz)
+	mux.HandleFunc(readyzPath, getReadyz)
+}
 
-	// Extract limit and offset parameters from query string
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
+// Function to dispatch the routes under a single material, e.g. /high-voltage/{id}/replacements
+func materialRoutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, hvMotorPath+"/"), "/"), "/")
 
-	// Convert parameters to integers with default values if not provided
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit <= 0 {
-		limit = 0
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
 	}
 
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil || offset < 0 {
-		offset = 0
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		getMaterial(w, r, hvMotorCategory, id)
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		createMaterial(w, r, hvMotorCategory, id)
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		replaceMaterial(w, r, hvMotorCategory, id)
+	case len(parts) == 1 && r.Method == http.MethodPatch:
+		patchMaterial(w, r, hvMotorCategory, id)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		deleteMaterial(w, r, hvMotorCategory, id)
+	case len(parts) == 1:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	case len(parts) == 2 && parts[1] == "rotor-bar-checks" && r.Method == http.MethodGet:
+		getRotorBarChecks(w, r, id)
+	case len(parts) == 2 && parts[1] == "rotor-bar-checks" && r.Method == http.MethodPost:
+		createRotorBarCheck(w, r, id)
+	case len(parts) == 2 && parts[1] == "starting-current-events" && r.Method == http.MethodGet:
+		getStartingCurrentEvents(w, r, id)
+	case len(parts) == 2 && parts[1] == "starting-current-events" && r.Method == http.MethodPost:
+		createStartingCurrentEvent(w, r, id)
+	case len(parts) == 2 && parts[1] == "replacements":
+		getReplacements(w, r, id)
+	case len(parts) == 2 && parts[1] == "movements" && r.Method == http.MethodGet:
+		getStockMovements(w, r, id)
+	case len(parts) == 2 && parts[1] == "movements" && r.Method == http.MethodPost:
+		createStockMovement(w, r, id)
+	case len(parts) == 2 && parts[1] == "history":
+		getMaterialHistory(w, r, id)
+	default:
+		http.NotFound(w, r)
 	}
+}
 
-	// Perform raw query
-	rows, err := db.Query(context.Background(),
-		`SELECT plant, area, category, name, capacity, voltage, current, rpm, shaft_diameter, base_width, base_length, c, e, h, maker, id, qcode, frame, installed_qty, standby_qty, spare_qty
-		FROM public.list_materials`)
-	if err != nil {
-		http.Error(w, "Error querying the database", http.StatusInternalServerError)
+func getMaterials(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	defer rows.Close()
 
-	// Paginate the results in the code
-	var materials []Material
-	for rows.Next() {
-		var material Material
-		err := rows.Scan(
-			&material.Plant, &material.Area, &material.Category, &material.Name,
-			&material.Specifications.Capacity, &material.Specifications.Voltage, &material.Specifications.Current,
-			&material.Specifications.RPM, &material.Size.ShaftDiameter, &material.Size.BaseWidth,
-			&material.Size.BaseLength, &material.Size.C, &material.Size.E, &material.Size.H,
-			&material.Maker, &material.ID, &material.QCode, &material.Frame, &material.Installed, &material.StandBy,
-			&material.Spare,
-		)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Error scanning row %v", err.Error()), http.StatusInternalServerError)
-			return
-		}
-		materials = append(materials, material)
-	}
-
-	// Apply pagination in the code
-	startIndex := offset
-	endIndex := offset + limit
-	if endIndex > len(materials) || limit == 0 {
-		endIndex = len(materials)
-	}
-	paginatedData := materials[startIndex:endIndex]
+	// Extract limit and offset parameters from query string
+	query := newQueryParser(r)
+	limit, offset := query.pagination()
 
-	// Construct response
-	response := APIResponse{
-		Request: struct {
-			Limit  int `json:"limit"`
-			Offset int `json:"offset"`
-		}{Limit: limit, Offset: offset},
-		Response: struct {
-			Count   int        `json:"count"`
-			Success bool       `json:"success"`
-			Data    []Material `json:"data"`
-		}{Count: len(paginatedData), Success: true, Data: paginatedData},
-	}
-
-	// Marshal response to JSON
-	jsonResponse, err := json.Marshal(response)
-	if err != nil {
-		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+	// No filters, every HV motor is a match
+	params := QueryParams{Category: hvMotorCategory.Name, Sort: query.sort("sort", sortFields())}
+	if !query.valid(w) {
 		return
 	}
-
-	// Set response headers and write JSON response
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonResponse)
+	writeMaterialsPage(w, r, params, limit, offset)
 }
 
 func getMaterialsByParams(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers to the response
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Credentials", "true")
-	w.Header().Add("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-	w.Header().Add("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	// Extract limit and offset parameters from query string
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
+	query := newQueryParser(r)
+	limit, offset := query.pagination()
 
-	// Convert parameters to integers with default values if not provided
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit <= 0 {
-		limit = 0
+	// Parse query parameters from the request URL, an invalid value is an error rather than no filter
+	params := QueryParams{
+		Category:      hvMotorCategory.Name,
+		Capacity:      query.numericFilter("capacity", hvMotorCategory.unit("capacity")),
+		Voltage:       query.numericFilter("voltage", hvMotorCategory.unit("voltage")),
+		Current:       query.numericFilter("current", hvMotorCategory.unit("current")),
+		RPM:           query.numericFilter("rpm", hvMotorCategory.unit("rpm")),
+		ShaftDiameter: query.numericFilter("shaft_diameter", hvMotorCategory.unit("shaft_diameter")),
+		BaseWidth:     query.numericFilter("base_width", hvMotorCategory.unit("base_width")),
+		BaseLength:    query.numericFilter("base_length", hvMotorCategory.unit("base_length")),
+		C:             query.numericFilter("c", hvMotorCategory.unit("c")),
+		E:             query.numericFilter("e", hvMotorCategory.unit("e")),
+		H:             query.numericFilter("h", hvMotorCategory.unit("h")),
+		Frame:         query.numericFilter("frame", hvMotorCategory.unit("frame")),
+		Search:        query.search("q"),
+		Facets:        query.facets("facets"),
+		Sort:          query.sort("sort", sortFields()),
+	}
+	if !query.valid(w) {
+		return
 	}
 
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil || offset < 0 {
-		offset = 0
+	writeMaterialsPage(w, r, params, limit, offset)
+}
+
+// Function to query one page of materials plus the total match count and write it as JSON, or export it as CSV or XLSX
+func writeMaterialsPage(w http.ResponseWriter, r *http.Request, params QueryParams, limit, offset int) {
+	// CSV and XLSX are streamed row by row instead of building the JSON page
+	format, ok := exportFormat(r)
+	if !ok {
+		writeInputError(w, InputError{Field: "format", Value: r.URL.Query().Get("format"), Reason: "must be json, csv or xlsx"})
+		return
+	}
+	if format != FormatJSON {
+		exportMaterials(w, r, format, params, limit, offset)
+		return
 	}
 
-	// Parse query parameters from the request URL
-	params := QueryParams{
-		Capacity:      parseFloatQueryParam(r, "capacity"),
-		Voltage:       parseFloatQueryParam(r, "voltage"),
-		Current:       parseFloatQueryParam(r, "current"),
-		RPM:           parseFloatQueryParam(r, "rpm"),
-		ShaftDiameter: parseFloatQueryParam(r, "shaft_diameter"),
-		BaseWidth:     parseFloatQueryParam(r, "base_width"),
-		BaseLength:    parseFloatQueryParam(r, "base_length"),
-		C:             parseFloatQueryParam(r, "c"),
-		E:             parseFloatQueryParam(r, "e"),
-		H:             parseFloatQueryParam(r, "h"),
-		Frame:         parseFloatQueryParam(r, "frame"),
-	}
-
-	// Execute the dynamic SELECT query
-	materials, err := selectMaterialsByParams(context.Background(), db, params)
+	list := materialRepo.List
+	if params.Search != "" {
+		list = materialRepo.Search
+	}
+	page, err := list(r.Context(), params, limit, offset)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error selecting materials: %v", err), http.StatusInternalServerError)
 		return
 	}
+	materials, total := page.Materials, page.Total
+	if materials == nil {
+		materials = []Material{}
+	}
 
-	// Check if there are no matching records
-	if len(materials) == 0 {
-		// Construct a default response
-		defaultResponse := APIResponse{
-			Request: struct {
-				Limit  int `json:"limit"`
-				Offset int `json:"offset"`
-			}{Limit: limit, Offset: offset},
-			Response: struct {
-				Count   int        `json:"count"`
-				Success bool       `json:"success"`
-				Data    []Material `json:"data"`
-			}{Count: 0, Success: true, Data: []Material{}},
-		}
-
-		// Marshal default response to JSON
-		jsonResponse, err := json.Marshal(defaultResponse)
+	// Facets count every match, not only the page
+	var facets map[string][]FacetValue
+	if len(params.Facets) > 0 {
+		facets, err = materialRepo.Facets(r.Context(), params)
 		if err != nil {
-			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Error counting facets: %v", err), http.StatusInternalServerError)
 			return
 		}
-
-		// Set response headers and write JSON response
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(jsonResponse)
-		return
 	}
 
-	// Apply pagination in the code
-	startIndex := offset
-	endIndex := offset + limit
-	if endIndex > len(materials) || limit == 0 {
-		endIndex = len(materials)
-	}
-	paginatedData := materials[startIndex:endIndex]
-
 	// Construct response
-	response := APIResponse{
-		Request: struct {
-			Limit  int `json:"limit"`
-			Offset int `json:"offset"`
-		}{Limit: limit, Offset: offset},
-		Response: struct {
-			Count   int        `json:"count"`
-			Success bool       `json:"success"`
-			Data    []Material `json:"data"`
-		}{Count: len(paginatedData), Success: true, Data: paginatedData},
-	}
-
-	// Marshal response to JSON
-	jsonResponse, err := json.Marshal(response)
+	var response APIResponse
+	response.Request.Limit = limit
+	response.Request.Offset = offset
+	response.Response.Count = len(materials)
+	response.Response.Total = total
+	response.Response.Next, response.Response.Prev = pageCursors(r, limit, offset, total)
+	response.Response.Success = true
+	response.Response.Data = materials
+	response.Response.Facets = facets
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Function to marshal v and write it as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	jsonResponse, err := json.Marshal(v)
 	if err != nil {
 		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
 		return
 	}
 
-	// Set response headers and write JSON response
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(jsonResponse)
 }
 
-// Function to parse a float query parameter from the request
-func parseFloatQueryParam(r *http.Request, paramName string) int {
-	paramValue := r.URL.Query().Get(paramName)
-	if paramValue == "" {
-		return 0
+// Function to build the next and prev page links, nil when there is no such page
+func pageCursors(r *http.Request, limit, offset, total int) (next, prev *string) {
+	link := func(offset int) *string {
+		query := r.URL.Query()
+		query.Set("offset", strconv.Itoa(offset))
+		url := r.URL.Path + "?" + query.Encode()
+		return &url
 	}
 
-	floatValue, err := strconv.ParseFloat(paramValue, 32)
-	if err != nil {
-		return 0
+	if limit > 0 && offset+limit < total {
+		next = link(offset + limit)
+	}
+	if offset > 0 {
+		prevOffset := 0
+		if limit > 0 && offset-limit > 0 {
+			prevOffset = offset - limit
+		}
+		// Jumping past the end lands on the last page instead of an empty one
+		if limit > 0 && prevOffset >= total && total > 0 {
+			prevOffset = (total - 1) / limit * limit
+		}
+		prev = link(prevOffset)
 	}
 
-	return int(floatValue)
+	return next, prev
 }
 
-// Function to execute the dynamic SELECT query
-func selectMaterialsByParams(ctx context.Context, db *pgxpool.Pool, params QueryParams) ([]Material, error) {
-	query, values := buildSelectQuery(params)
+// Function to count every material matching the parameters, ignoring pagination
+func countMaterialsByParams(ctx context.Context, db querier, params QueryParams) (int, error) {
+	query, values := buildCountQuery(params)
 
-	fmt.Println("QUERY: ", query)
+	var total int
+	if err := db.QueryRow(ctx, query, values...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("unable to execute count query: %w", err)
+	}
 
-	rows, err := db.Query(ctx, query, values...)
+	return total, nil
+}
+
+// Function to execute the dynamic SELECT query
+func selectMaterialsByParams(ctx context.Context, db querier, params QueryParams, limit, offset int) ([]Material, error) {
+	rows, err := queryMaterialsByParams(ctx, db, params, limit, offset)
 	if err != nil {
-		return nil, fmt.Errorf("unable to execute query: %w", err)
+		return nil, err
 	}
 	defer rows.Close()
 
-	// Paginate the results in the code
 	var materials []Material
 	for rows.Next() {
-		var material Material
-		err := rows.Scan(
-			&material.Plant, &material.Area, &material.Category, &material.Name,
-			&material.Specifications.Capacity, &material.Specifications.Voltage, &material.Specifications.Current,
-			&material.Specifications.RPM, &material.Size.ShaftDiameter, &material.Size.BaseWidth,
-			&material.Size.BaseLength, &material.Size.C, &material.Size.E, &material.Size.H,
-			&material.Maker, &material.ID, &material.QCode, &material.Frame, &material.Installed, &material.StandBy,
-			&material.Spare,
-		)
+		material, err := scanMaterialByParams(rows, params)
 		if err != nil {
-			fmt.Printf("Error scanning row %v", err.Error())
-			return nil, err
+			return nil, fmt.Errorf("error scanning row: %w", err)
 		}
 		materials = append(materials, material)
 	}
@@ -384,55 +578,139 @@ func selectMaterialsByParams(ctx context.Context, db *pgxpool.Pool, params Query
 	return materials, nil
 }
 
-// Function to build a dynamic SELECT query based on the provided parameters
-func buildSelectQuery(params QueryParams) (string, []interface{}) {
-	query := "SELECT plant, area, category, name, capacity, voltage, current, rpm, shaft_diameter, base_width, base_length, c, e, h, maker, id, qcode, frame, installed_qty, standby_qty, spare_qty FROM public.list_materials WHERE true"
-	var values []interface{}
+// Function to start the dynamic SELECT query, rows are read from the connection as they are scanned
+func queryMaterialsByParams(ctx context.Context, db querier, params QueryParams, limit, offset int) (pgx.Rows, error) {
+	query, values := buildSelectQuery(params, limit, offset)
+	rows, err := db.Query(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query: %w", err)
+	}
 
-	// Check each parameter and add it to the query if it's not zero
-	if params.Capacity != 0 {
-		query += " AND capacity >= $" + strconv.Itoa(len(values)+1)
-		values = append(values, params.Capacity)
+	return rows, nil
+}
+
+// Function to select a single material by its ID, pgx.ErrNoRows when it does not exist
+func selectMaterialByID(ctx context.Context, db querier, id int) (Material, error) {
+	row := db.QueryRow(ctx, "SELECT "+materialColumns+" FROM public.list_materials WHERE id = $1", id)
+	return scanMaterial(row)
+}
+
+// Function to select a material and lock its row until the transaction ends
+func selectMaterialForUpdate(ctx context.Context, tx pgx.Tx, id int) (Material, error) {
+	row := tx.QueryRow(ctx, "SELECT "+materialColumns+" FROM public.list_materials WHERE id = $1 FOR UPDATE", id)
+	return scanMaterial(row)
+}
+
+// Function to scan one row selected with materialColumns
+func scanMaterial(row pgx.Row) (Material, error) {
+	var material Material
+	err := row.Scan(materialTargets(&material)...)
+	if category, ok := categoryByName(material.Category); ok {
+		material.Units = category.units()
 	}
-	if params.Frame != 0 {
-		query += " AND frame = $" + strconv.Itoa(len(values)+1)
-		values = append(values, params.Frame)
+	return material, err
+}
+
+// Function to scan one row of buildSelectQuery, which adds the rank and matched field when searching
+func scanMaterialByParams(row pgx.Row, params QueryParams) (Material, error) {
+	if params.Search == "" {
+		return scanMaterial(row)
 	}
-	if params.Voltage != 0 {
-		query += " AND voltage = $" + strconv.Itoa(len(values)+1)
-		values = append(values, params.Voltage)
+
+	var material Material
+	var rank float64
+	var field string
+	err := row.Scan(append(materialTargets(&material), &rank, &field)...)
+	if category, ok := categoryByName(material.Category); ok {
+		material.Units = category.units()
 	}
-	if params.Current != 0 {
-		query += " AND current = $" + strconv.Itoa(len(values)+1)
-		values = append(values, params.Current)
+	material.Match = newSearchMatch(material, params.Search, field, rank)
+	return material, err
+}
+
+// Function to list the fields of material in the order of materialColumns
+func materialTargets(material *Material) []interface{} {
+	return []interface{}{
+		&material.Plant, &material.Area, &material.Category, &material.Name,
+		&material.Specifications.Capacity, &material.Specifications.Voltage, &material.Specifications.Current,
+		&material.Specifications.RPM, &material.Size.ShaftDiameter, &material.Size.BaseWidth,
+		&material.Size.BaseLength, &material.Size.C, &material.Size.E, &material.Size.H,
+		&material.Maker, &material.ID, &material.QCode, &material.Frame, &material.Installed, &material.StandBy,
+		&material.Spare, &material.SerialNumber, &material.Type,
+		&material.StartingCurrent.When, &material.StartingCurrent.Check, &material.StartingCurrent.Frequency,
+		&material.StartingCurrent.LastCheck, &material.StartingCurrent.LastStart, &material.StartingCurrent.LastOverhaul,
+		&material.RotorBar.CheckDate, &material.RotorBar.CheckStatus, &material.RotorBar.Reason, &material.RotorBar.Remark,
+		&material.Operation, &material.Remark, &material.Specs,
+		&material.PIC.Team, &material.PIC.Name, &material.PIC.Phone, &material.PIC.Email, &material.CreatedAt, &material.UpdatedAt,
 	}
-	if params.RPM != 0 {
-		query += " AND rpm = $" + strconv.Itoa(len(values)+1)
-		values = append(values, params.RPM)
+}
+
+// Function to build a dynamic SELECT query based on the provided parameters
+func buildSelectQuery(params QueryParams, limit, offset int) (string, []interface{}) {
+	where, values := buildWhereClause(params)
+
+	// A stable order is required for LIMIT/OFFSET pages to be repeatable, search results come best match first
+	orderBy := buildOrderBy(params.Sort)
+	var query string
+	if params.Search != "" {
+		_, rank, field := searchClauses("$"+strconv.Itoa(len(values)+1), "$"+strconv.Itoa(len(values)+2))
+		values = append(values, searchTSQuery(params.Search), searchKey(params.Search))
+		query = "SELECT " + materialColumns + ", " + rank + " AS rank, " + field + " AS matched_field FROM public.list_materials" + where
+		if len(params.Sort) == 0 {
+			orderBy = " ORDER BY rank DESC, id"
+		}
+	} else {
+		query = "SELECT " + materialColumns + " FROM public.list_materials" + where
 	}
-	if params.ShaftDiameter != 0 {
-		query += " AND shaft_diameter = $" + strconv.Itoa(len(values)+1)
-		values = append(values, params.ShaftDiameter)
+	query += orderBy
+	if limit > 0 {
+		query += " LIMIT $" + strconv.Itoa(len(values)+1)
+		values = append(values, limit)
 	}
-	if params.BaseWidth != 0 {
-		query += " AND base_width = $" + strconv.Itoa(len(values)+1)
-		values = append(values, params.BaseWidth)
+	if offset > 0 {
+		query += " OFFSET $" + strconv.Itoa(len(values)+1)
+		values = append(values, offset)
 	}
-	if params.BaseLength != 0 {
-		query += " AND base_length = $" + strconv.Itoa(len(values)+1)
-		values = append(values, params.BaseLength)
+
+	return query, values
+}
+
+// Function to build a COUNT query sharing the WHERE clause of buildSelectQuery
+func buildCountQuery(params QueryParams) (string, []interface{}) {
+	where, values := buildWhereClause(params)
+	return "SELECT COUNT(*) FROM public.list_materials" + where, values
+}
+
+// Function to build the WHERE clause for the provided parameters
+func buildWhereClause(params QueryParams) (string, []interface{}) {
+	query := " WHERE true"
+	var values []interface{}
+
+	if params.Category != "" {
+		query += " AND category = $" + strconv.Itoa(len(values)+1)
+		values = append(values, params.Category)
 	}
-	if params.C != 0 {
-		query += " AND c = $" + strconv.Itoa(len(values)+1)
-		values = append(values, params.C)
+	for _, text := range params.Texts {
+		query += " AND " + text.Column + " = $" + strconv.Itoa(len(values)+1)
+		values = append(values, text.Value)
 	}
-	if params.E != 0 {
-		query += " AND e = $" + strconv.Itoa(len(values)+1)
-		values = append(values, params.E)
+	if params.Search != "" {
+		search, _, _ := searchClauses("$"+strconv.Itoa(len(values)+1), "$"+strconv.Itoa(len(values)+2))
+		query += " AND " + search
+		values = append(values, searchTSQuery(params.Search), searchKey(params.Search))
 	}
-	if params.H != 0 {
-		query += " AND h = $" + strconv.Itoa(len(values)+1)
-		values = append(values, params.H)
+
+	// Check each parameter and add its bounds to the query if any is set
+	for _, column := range params.numericColumns() {
+		lower, upper := column.Filter.Bounds(column.AtLeast)
+		if lower != nil {
+			query += " AND " + column.Column + " >= $" + strconv.Itoa(len(values)+1) + "::numeric"
+			values = append(values, *lower)
+		}
+		if upper != nil {
+			query += " AND " + column.Column + " <= $" + strconv.Itoa(len(values)+1) + "::numeric"
+			values = append(values, *upper)
+		}
 	}
 
 	return query, values
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// uniqueViolation is the Postgres error code of a duplicate key
+const uniqueViolation = "23505"
+
+type MaterialResponse struct {
+	Response struct {
+		Success bool     `json:"success"`
+		Data    Material `json:"data"`
+	} `json:"response"`
+}
+
+func getMaterial(w http.ResponseWriter, r *http.Request, category Category, id int) {
+	material, err := getMaterialOf(r.Context(), category, id)
+	if err != nil {
+		writeMaterialLookupError(w, id, err)
+		return
+	}
+
+	writeMaterial(w, http.StatusOK, material)
+}
+
+func createMaterial(w http.ResponseWriter, r *http.Request, category Category, id int) {
+	var material Material
+	if err := decodeMaterial(r, &material); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	if err := prepareMaterial(&material, category, id); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	if !authorize(w, r, RolePlanner, material.Plant) {
+		return
+	}
+
+	created, err := materialRepo.Create(r.Context(), material, requestChange(r))
+	if errors.Is(err, ErrMaterialExists) {
+		http.Error(w, fmt.Sprintf("Material %d already exists", id), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error inserting material: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeMaterial(w, http.StatusCreated, created)
+}
+
+func replaceMaterial(w http.ResponseWriter, r *http.Request, category Category, id int) {
+	stored, err := getMaterialOf(r.Context(), category, id)
+	if err != nil {
+		writeMaterialLookupError(w, id, err)
+		return
+	}
+
+	// PUT replaces every field, anything missing from the body is reset to its zero value
+	var material Material
+	if err := decodeMaterial(r, &material); err != nil {
+		writeInputError(w, err)
+		return
+	}
+
+	saveMaterial(w, r, category, id, stored, material)
+}
+
+func patchMaterial(w http.ResponseWriter, r *http.Request, category Category, id int) {
+	material, err := getMaterialOf(r.Context(), category, id)
+	if err != nil {
+		writeMaterialLookupError(w, id, err)
+		return
+	}
+
+	// PATCH decodes on top of the stored material so only the fields in the body change
+	stored := material
+	if err := decodeMaterial(r, &material); err != nil {
+		writeInputError(w, err)
+		return
+	}
+
+	saveMaterial(w, r, category, id, stored, material)
+}
+
+func deleteMaterial(w http.ResponseWriter, r *http.Request, category Category, id int) {
+	material, err := getMaterialOf(r.Context(), category, id)
+	if err != nil {
+		writeMaterialLookupError(w, id, err)
+		return
+	}
+	if !authorize(w, r, RoleMaintenanceAdmin, material.Plant) {
+		return
+	}
+
+	err = materialRepo.Delete(r.Context(), id, requestChange(r))
+	if errors.Is(err, ErrMaterialNotFound) {
+		http.Error(w, fmt.Sprintf("Material %d not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error deleting material: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Function to validate and update a material, shared by PUT and PATCH.
+// Moving a material to another plant needs rights on both plants.
+func saveMaterial(w http.ResponseWriter, r *http.Request, category Category, id int, stored Material, material Material) {
+	if err := prepareMaterial(&material, category, id); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	if !authorize(w, r, RolePlanner, stored.Plant, material.Plant) {
+		return
+	}
+
+	updated, err := materialRepo.Update(r.Context(), material, requestChange(r))
+	if errors.Is(err, ErrMaterialNotFound) {
+		http.Error(w, fmt.Sprintf("Material %d not found", id), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrMaterialExists) {
+		http.Error(w, fmt.Sprintf("Material %d conflicts with an existing material", id), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error updating material: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeMaterial(w, http.StatusOK, updated)
+}
+
+// Function to select a material of category, a material of another category is not found on its route
+func getMaterialOf(ctx context.Context, category Category, id int) (Material, error) {
+	material, err := materialRepo.Get(ctx, id)
+	if err != nil {
+		return Material{}, err
+	}
+	if material.Category != category.Name {
+		return Material{}, ErrMaterialNotFound
+	}
+	return material, nil
+}
+
+// Function to write a single material response
+func writeMaterial(w http.ResponseWriter, status int, material Material) {
+	var response MaterialResponse
+	response.Response.Success = true
+	response.Response.Data = material
+
+	writeJSON(w, status, response)
+}
+
+// Function to decode a JSON request body into material, unknown fields are rejected
+func decodeMaterial(r *http.Request, material *Material) error {
+	return decodeJSON(r, material)
+}
+
+// Function to apply the path ID and defaults to a decoded material and validate it.
+// Every material on a route is of the category of the route, any other category is rejected rather than moved there.
+func prepareMaterial(material *Material, category Category, id int) error {
+	if material.ID != 0 && material.ID != id {
+		return InputError{Field: "id", Value: strconv.Itoa(material.ID), Reason: fmt.Sprintf("does not match the path id %d", id)}
+	}
+	material.ID = id
+	if strings.TrimSpace(material.Category) == "" {
+		material.Category = category.Name
+	}
+	if material.Category != category.Name {
+		return InputError{Field: "category", Value: material.Category, Reason: fmt.Sprintf("must be %s on this route", category.Name)}
+	}
+	if material.Specs == nil {
+		material.Specs = map[string]interface{}{}
+	}
+
+	return validateMaterial(*material)
+}
+
+// Function to check the required fields and value ranges of a material, the problems are returned as InputErrors
+func validateMaterial(material Material) error {
+	var problems InputErrors
+
+	required := map[string]string{
+		"plant": material.Plant,
+		"area":  material.Area,
+		"name":  material.Name,
+	}
+	for _, field := range []string{"plant", "area", "name"} {
+		if strings.TrimSpace(required[field]) == "" {
+			problems = append(problems, InputError{Field: field, Value: required[field], Reason: "is required"})
+		}
+	}
+
+	nonNegative := append(attributeColumns(material), []attributeColumn{
+		{Field: "installed_qty", Value: float64(material.Installed)},
+		{Field: "standby_qty", Value: float64(material.StandBy)},
+		{Field: "spare_qty", Value: float64(material.Spare)},
+	}...)
+	for _, value := range nonNegative {
+		if value.Value < 0 {
+			problems = append(problems, InputError{Field: value.Field, Value: strconv.FormatFloat(value.Value, 'f', -1, 64), Reason: "must not be negative"})
+		}
+	}
+
+	if category, ok := categoryByName(material.Category); ok {
+		problems = append(problems, validateSpecs(category, material.Specs)...)
+		problems = append(problems, validateColumns(category, material)...)
+	} else {
+		problems = append(problems, InputError{Field: "category", Value: material.Category, Reason: "is not a known category"})
+	}
+
+	if !validFrequency(material.StartingCurrent.Frequency) {
+		problems = append(problems, InputError{
+			Field: "starting_current.frequency", Value: material.StartingCurrent.Frequency,
+			Reason: "must be one of " + strings.Join(startingCurrentFrequencies, ", ") + " or empty",
+		})
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
+
+// attributeColumn is the value of an attribute with a column of its own, Field is its JSON path
+type attributeColumn struct {
+	Field string
+	Name  string
+	Value float64
+}
+
+// Function to list the values of the attribute columns of a material, they predate specs and belong to HV Motor
+func attributeColumns(material Material) []attributeColumn {
+	return []attributeColumn{
+		{"specifications.capacity", "capacity", material.Specifications.Capacity},
+		{"specifications.voltage", "voltage", material.Specifications.Voltage},
+		{"specifications.current", "current", material.Specifications.Current},
+		{"specifications.rpm", "rpm", material.Specifications.RPM},
+		{"size.shaft_diameter", "shaft_diameter", material.Size.ShaftDiameter},
+		{"size.base_width", "base_width", material.Size.BaseWidth},
+		{"size.base_length", "base_length", material.Size.BaseLength},
+		{"size.c", "c", material.Size.C},
+		{"size.e", "e", material.Size.E},
+		{"size.h", "h", material.Size.H},
+		{"frame", "frame", float64(material.Frame)},
+	}
+}
+
+// Function to build the named arguments of the INSERT and UPDATE queries. The rotor_bar columns are
+// only listed for the audit diff, derive_rotor_bar_status sets them from the checks of the material.
+func materialArgs(material Material) pgx.NamedArgs {
+	return pgx.NamedArgs{
+		"id":                             material.ID,
+		"qcode":                          material.QCode,
+		"plant":                          material.Plant,
+		"area":                           material.Area,
+		"category":                       material.Category,
+		"name":                           material.Name,
+		"capacity":                       material.Specifications.Capacity,
+		"voltage":                        material.Specifications.Voltage,
+		"current":                        material.Specifications.Current,
+		"rpm":                            material.Specifications.RPM,
+		"shaft_diameter":                 material.Size.ShaftDiameter,
+		"base_width":                     material.Size.BaseWidth,
+		"base_length":                    material.Size.BaseLength,
+		"c":                              material.Size.C,
+		"e":                              material.Size.E,
+		"h":                              material.Size.H,
+		"maker":                          material.Maker,
+		"installed_qty":                  material.Installed,
+		"standby_qty":                    material.StandBy,
+		"spare_qty":                      material.Spare,
+		"frame":                          material.Frame,
+		"serial_number":                  material.SerialNumber,
+		"type":                           material.Type,
+		"starting_current_when":          material.StartingCurrent.When,
+		"starting_current_check":         material.StartingCurrent.Check,
+		"starting_current_frequency":     material.StartingCurrent.Frequency,
+		"starting_current_last_check":    material.StartingCurrent.LastCheck,
+		"starting_current_last_start":    material.StartingCurrent.LastStart,
+		"starting_current_last_overhaul": material.StartingCurrent.LastOverhaul,
+		"rotor_bar_check_date":           material.RotorBar.CheckDate,
+		"rotor_bar_check_status":         material.RotorBar.CheckStatus,
+		"rotor_bar_reason":               material.RotorBar.Reason,
+		"rotor_bar_remark":               material.RotorBar.Remark,
+		"operation":                      material.Operation,
+		"remark":                         material.Remark,
+		"specs":                          material.Specs,
+		"pic_team":                       material.PIC.Team,
+		"pic_name":                       material.PIC.Name,
+		"pic_phone":                      material.PIC.Phone,
+		"pic_email":                      material.PIC.Email,
+	}
+}
+
+// Function to insert a material and return the stored row, the rotor_bar columns of the request are not written
+func insertMaterial(ctx context.Context, db querier, material Material) (Material, error) {
+	query := `INSERT INTO public.list_materials
+	(id, qcode, plant, area, category, name, capacity, voltage, current, rpm, shaft_diameter, base_width, base_length, c, e, h, maker, installed_qty, standby_qty, spare_qty, frame,
+	serial_number, type, starting_current_when, starting_current_check,
+	starting_current_frequency, starting_current_last_check, starting_current_last_start, starting_current_last_overhaul,
+	operation, remark, specs,
+	pic_team, pic_name, pic_phone, pic_email, created_at, updated_at)
+	VALUES(@id, @qcode, @plant, @area, @category, @name, @capacity, @voltage, @current, @rpm, @shaft_diameter, @base_width, @base_length, @c, @e, @h, @maker, @installed_qty, @standby_qty, @spare_qty, @frame,
+	@serial_number, @type, @starting_current_when, @starting_current_check,
+	@starting_current_frequency, @starting_current_last_check, @starting_current_last_start, @starting_current_last_overhaul,
+	@operation, @remark, @specs,
+	@pic_team, @pic_name, @pic_phone, @pic_email, now(), now())
+	RETURNING ` + materialColumns
+
+	return scanMaterial(db.QueryRow(ctx, query, materialArgs(material)))
+}
+
+// Function to update every column of a material but the rotor_bar ones and bump updated_at, pgx.ErrNoRows when it does not exist
+func updateMaterial(ctx context.Context, db querier, material Material) (Material, error) {
+	query := `UPDATE public.list_materials SET
+	qcode = @qcode, plant = @plant, area = @area, category = @category, name = @name,
+	capacity = @capacity, voltage = @voltage, current = @current, rpm = @rpm,
+	shaft_diameter = @shaft_diameter, base_width = @base_width, base_length = @base_length, c = @c, e = @e, h = @h,
+	maker = @maker, installed_qty = @installed_qty, standby_qty = @standby_qty, spare_qty = @spare_qty, frame = @frame,
+	serial_number = @serial_number, type = @type, starting_current_when = @starting_current_when, starting_current_check = @starting_current_check,
+	starting_current_frequency = @starting_current_frequency, starting_current_last_check = @starting_current_last_check,
+	starting_current_last_start = @starting_current_last_start, starting_current_last_overhaul = @starting_current_last_overhaul,
+	operation = @operation, remark = @remark, specs = @specs,
+	pic_team = @pic_team, pic_name = @pic_name, pic_phone = @pic_phone, pic_email = @pic_email,
+	updated_at = now()
+	WHERE id = @id
+	RETURNING ` + materialColumns
+
+	return scanMaterial(db.QueryRow(ctx, query, materialArgs(material)))
+}
+
+// Function to delete a material, pgx.ErrNoRows when it does not exist
+func deleteMaterialByID(ctx context.Context, db querier, id int) error {
+	tag, err := db.Exec(ctx, "DELETE FROM public.list_materials WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("unable to delete row: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// Function to report whether err is a Postgres duplicate key error
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
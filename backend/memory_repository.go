@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryMaterialRepository keeps materials and their records in memory, so the handlers run without Postgres.
+// It is plain storage: it neither filters, sorts, searches nor counts facets, and derives nothing from the records.
+// What it was asked for is kept in lastParams and lastAuditFilter, the SQL answering it is tested against Postgres.
+type memoryMaterialRepository struct {
+	mu        sync.RWMutex
+	materials map[int]Material
+	// checks, movements and events are the records of every material, oldest first
+	checks    []RotorBarCheck
+	movements []StockMovement
+	events    []StartingCurrentEvent
+	// lastRecordID is the last ID given to a record, like the bigserial of its table
+	lastRecordID int64
+	// coverage and unclassified are returned by SpareCoverage, audit is paged by AuditLog, as they are
+	coverage     []FamilyCoverage
+	unclassified int
+	audit        []AuditEntry
+	// lastParams and lastAuditFilter are the arguments of the latest List, Search, Facets or Stream and AuditLog
+	lastParams      QueryParams
+	lastAuditFilter auditFilter
+}
+
+// Function to create a repository holding materials
+func newMemoryMaterialRepository(materials ...Material) *memoryMaterialRepository {
+	repo := &memoryMaterialRepository{materials: map[int]Material{}}
+	for _, material := range materials {
+		repo.materials[material.ID] = cloneMaterial(material)
+	}
+	return repo
+}
+
+func (repo *memoryMaterialRepository) List(ctx context.Context, params QueryParams, limit, offset int) (MaterialPage, error) {
+	return repo.page(params, limit, offset), nil
+}
+
+func (repo *memoryMaterialRepository) Search(ctx context.Context, params QueryParams, limit, offset int) (MaterialPage, error) {
+	return repo.page(params, limit, offset), nil
+}
+
+// Function to record params and return a page of every material by ID
+func (repo *memoryMaterialRepository) page(params QueryParams, limit, offset int) MaterialPage {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.lastParams = params
+	materials := make([]Material, 0, len(repo.materials))
+	for _, material := range repo.materials {
+		materials = append(materials, cloneMaterial(material))
+	}
+	sort.Slice(materials, func(i, j int) bool { return materials[i].ID < materials[j].ID })
+	return MaterialPage{Materials: pageOf(materials, limit, offset), Total: len(materials)}
+}
+
+func (repo *memoryMaterialRepository) Get(ctx context.Context, id int) (Material, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	material, ok := repo.materials[id]
+	if !ok {
+		return Material{}, ErrMaterialNotFound
+	}
+	return cloneMaterial(material), nil
+}
+
+func (repo *memoryMaterialRepository) Create(ctx context.Context, material Material, change MaterialChange) (Material, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.materials[material.ID]; ok {
+		return Material{}, ErrMaterialExists
+	}
+	material.CreatedAt = time.Now()
+	material.UpdatedAt = material.CreatedAt
+	repo.materials[material.ID] = cloneMaterial(material)
+	return cloneMaterial(material), nil
+}
+
+func (repo *memoryMaterialRepository) Update(ctx context.Context, material Material, change MaterialChange) (Material, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	stored, ok := repo.materials[material.ID]
+	if !ok {
+		return Material{}, ErrMaterialNotFound
+	}
+	material.Installed, material.StandBy, material.Spare = stored.Installed, stored.StandBy, stored.Spare
+	material.CreatedAt = stored.CreatedAt
+	material.UpdatedAt = time.Now()
+	repo.materials[material.ID] = cloneMaterial(material)
+	return cloneMaterial(material), nil
+}
+
+func (repo *memoryMaterialRepository) Delete(ctx context.Context, id int, change MaterialChange) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.materials[id]; !ok {
+		return ErrMaterialNotFound
+	}
+	delete(repo.materials, id)
+	return nil
+}
+
+// Function to record params and return no value for any facet
+func (repo *memoryMaterialRepository) Facets(ctx context.Context, params QueryParams) (map[string][]FacetValue, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.lastParams = params
+	facets := map[string][]FacetValue{}
+	for _, facet := range params.Facets {
+		facets[facet] = []FacetValue{}
+	}
+	return facets, nil
+}
+
+func (repo *memoryMaterialRepository) Stream(ctx context.Context, params QueryParams, limit, offset int) (MaterialRows, error) {
+	return &memoryMaterialRows{materials: repo.page(params, limit, offset).Materials}, nil
+}
+
+// memoryMaterialRows reads a page of materials that is already in memory
+type memoryMaterialRows struct {
+	materials []Material
+	next      int
+}
+
+func (rows *memoryMaterialRows) Next() bool {
+	rows.next++
+	return rows.next <= len(rows.materials)
+}
+
+func (rows *memoryMaterialRows) Material() (Material, error) {
+	return rows.materials[rows.next-1], nil
+}
+
+func (rows *memoryMaterialRows) Err() error {
+	return nil
+}
+
+func (rows *memoryMaterialRows) Close() {}
+
+func (repo *memoryMaterialRepository) RotorBarChecks(ctx context.Context, materialID int) ([]RotorBarCheck, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	checks := []RotorBarCheck{}
+	for i := len(repo.checks) - 1; i >= 0; i-- {
+		if repo.checks[i].MaterialID == materialID {
+			checks = append(checks, repo.checks[i])
+		}
+	}
+	return checks, nil
+}
+
+func (repo *memoryMaterialRepository) AddRotorBarCheck(ctx context.Context, check RotorBarCheck, change MaterialChange) (RotorBarCheck, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.materials[check.MaterialID]; !ok {
+		return RotorBarCheck{}, ErrMaterialNotFound
+	}
+	repo.lastRecordID++
+	check.ID, check.CreatedAt = repo.lastRecordID, time.Now()
+	repo.checks = append(repo.checks, check)
+	return check, nil
+}
+
+func (repo *memoryMaterialRepository) StockMovements(ctx context.Context, materialID int) ([]StockMovement, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	movements := []StockMovement{}
+	for i := len(repo.movements) - 1; i >= 0; i-- {
+		if repo.movements[i].MaterialID == materialID {
+			movements = append(movements, repo.movements[i])
+		}
+	}
+	return movements, nil
+}
+
+func (repo *memoryMaterialRepository) AddStockMovement(ctx context.Context, movement StockMovement, change MaterialChange) (StockMovement, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.materials[movement.MaterialID]; !ok {
+		return StockMovement{}, ErrMaterialNotFound
+	}
+	repo.lastRecordID++
+	movement.ID, movement.MovedAt = repo.lastRecordID, time.Now()
+	repo.movements = append(repo.movements, movement)
+	return movement, nil
+}
+
+func (repo *memoryMaterialRepository) StartingCurrentEvents(ctx context.Context, materialID int) ([]StartingCurrentEvent, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	events := []StartingCurrentEvent{}
+	for i := len(repo.events) - 1; i >= 0; i-- {
+		if repo.events[i].MaterialID == materialID {
+			events = append(events, repo.events[i])
+		}
+	}
+	return events, nil
+}
+
+func (repo *memoryMaterialRepository) AddStartingCurrentEvent(ctx context.Context, event StartingCurrentEvent, change MaterialChange) (StartingCurrentEvent, Material, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	material, ok := repo.materials[event.MaterialID]
+	if !ok {
+		return StartingCurrentEvent{}, Material{}, ErrMaterialNotFound
+	}
+	repo.lastRecordID++
+	event.ID, event.CreatedAt = repo.lastRecordID, time.Now()
+	repo.events = append(repo.events, event)
+	return event, cloneMaterial(material), nil
+}
+
+func (repo *memoryMaterialRepository) SpareCoverage(ctx context.Context, category string) ([]FamilyCoverage, int, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	families := make([]FamilyCoverage, len(repo.coverage))
+	for i, family := range repo.coverage {
+		family.Plants = append([]PlantCoverage{}, family.Plants...)
+		families[i] = family
+	}
+	return families, repo.unclassified, nil
+}
+
+// Function to record filter and return a page of the audit entries as they were given
+func (repo *memoryMaterialRepository) AuditLog(ctx context.Context, filter auditFilter, limit, offset int) (AuditPage, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.lastAuditFilter = filter
+	entries := append([]AuditEntry{}, repo.audit...)
+	return AuditPage{Entries: pageOf(entries, limit, offset), Total: len(entries)}, nil
+}
+
+// Function to cut a page out of items, a limit of 0 keeps every item from offset
+func pageOf[T any](items []T, limit, offset int) []T {
+	if offset > len(items) {
+		offset = len(items)
+	}
+	end := len(items)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return items[offset:end]
+}
+
+// Function to copy a material, so callers never share its maps with the repository
+func cloneMaterial(material Material) Material {
+	if material.Specs != nil {
+		specs := make(map[string]interface{}, len(material.Specs))
+		for key, value := range material.Specs {
+			specs[key] = value
+		}
+		material.Specs = specs
+	}
+	if material.Units != nil {
+		units := make(map[string]string, len(material.Units))
+		for key, value := range material.Units {
+			units[key] = value
+		}
+		material.Units = units
+	}
+	return material
+}
+
+// Function to copy every stored material by ID, for an import to validate against
+func (repo *memoryMaterialRepository) snapshot() map[int]Material {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	materials := make(map[int]Material, len(repo.materials))
+	for id, material := range repo.materials {
+		materials[id] = cloneMaterial(material)
+	}
+	return materials
+}
+
+// memoryUserRepository keeps users in memory, so requireAuth and the user handlers run without Postgres
+type memoryUserRepository struct {
+	mu     sync.RWMutex
+	users  map[int64]User
+	hashes map[int64]string
+	// lastID is the last ID given to a user, like the serial of the users table
+	lastID int64
+}
+
+// Function to create a repository holding users, they have no password until one is created through Create
+func newMemoryUserRepository(users ...User) *memoryUserRepository {
+	repo := &memoryUserRepository{users: map[int64]User{}, hashes: map[int64]string{}}
+	for _, user := range users {
+		repo.users[user.ID] = cloneUser(user)
+		if user.ID > repo.lastID {
+			repo.lastID = user.ID
+		}
+	}
+	return repo
+}
+
+func (repo *memoryUserRepository) Get(ctx context.Context, id int64) (User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	user, ok := repo.users[id]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (repo *memoryUserRepository) GetByUsername(ctx context.Context, username string) (User, string, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	username = strings.ToLower(strings.TrimSpace(username))
+	for id, user := range repo.users {
+		if user.Username == username {
+			return cloneUser(user), repo.hashes[id], nil
+		}
+	}
+	return User{}, "", errUserNotFound
+}
+
+func (repo *memoryUserRepository) List(ctx context.Context) ([]User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	users := []User{}
+	for _, user := range repo.users {
+		users = append(users, cloneUser(user))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (repo *memoryUserRepository) Create(ctx context.Context, user User, passwordHash string) (User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, stored := range repo.users {
+		if stored.Username == user.Username {
+			return User{}, errUserExists
+		}
+	}
+	repo.lastID++
+	user.ID = repo.lastID
+	repo.users[user.ID] = cloneUser(user)
+	repo.hashes[user.ID] = passwordHash
+	return cloneUser(user), nil
+}
+
+func (repo *memoryUserRepository) SetRoles(ctx context.Context, id int64, plantRoles []PlantRole) (User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	user, ok := repo.users[id]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	user.Roles = plantRoles
+	repo.users[id] = cloneUser(user)
+	return cloneUser(user), nil
+}
+
+// Function to copy a user, so callers never share its roles with the repository
+func cloneUser(user User) User {
+	user.Roles = append([]PlantRole{}, user.Roles...)
+	return user
+}
+
+// memoryImportRepository keeps import profiles and keys in memory and writes imports to a memory material repository
+type memoryImportRepository struct {
+	mu        sync.Mutex
+	materials *memoryMaterialRepository
+	profiles  map[string]ImportProfile
+	// keys are the import keys of the imported materials by ID, like the import_key column
+	keys map[int]string
+}
+
+func newMemoryImportRepository(materials *memoryMaterialRepository) *memoryImportRepository {
+	return &memoryImportRepository{materials: materials, profiles: map[string]ImportProfile{}, keys: map[int]string{}}
+}
+
+func (repo *memoryImportRepository) Profile(ctx context.Context, name string) (ImportProfile, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if name == defaultProfile {
+		return defaultImportProfile(), nil
+	}
+	profile, ok := repo.profiles[name]
+	if !ok {
+		return ImportProfile{}, errProfileNotFound
+	}
+	return profile, nil
+}
+
+func (repo *memoryImportRepository) Profiles(ctx context.Context) ([]ImportProfile, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	profiles := []ImportProfile{}
+	for _, profile := range repo.profiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return append([]ImportProfile{defaultImportProfile()}, profiles...), nil
+}
+
+func (repo *memoryImportRepository) SaveProfile(ctx context.Context, profile ImportProfile, actor string) (ImportProfile, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now()
+	profile.UpdatedBy, profile.UpdatedAt = actor, &now
+	repo.profiles[profile.Name] = profile
+	return profile, nil
+}
+
+// Function to validate rows against the stored materials and write the rows through the material repository,
+// the stock adjustments and audit entries the pgx repository writes along are left out
+func (repo *memoryImportRepository) Run(ctx context.Context, rows [][]string, options importOptions) (ImportReport, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	keys := make(map[int]string, len(repo.keys))
+	for id, key := range repo.keys {
+		keys[id] = key
+	}
+	report := validateImport(rows, options, repo.materials.snapshot(), keys)
+	if !importWritable(report, options) {
+		return report, nil
+	}
+
+	change := MaterialChange{Actor: options.Actor, Endpoint: options.Endpoint}
+	for _, row := range report.Rows {
+		var err error
+		switch row.Action {
+		case AuditCreate:
+			_, err = repo.materials.Create(ctx, row.material, change)
+		case AuditUpdate:
+			_, err = repo.materials.Update(ctx, row.material, change)
+		case AuditDelete:
+			err = repo.materials.Delete(ctx, row.MaterialID, change)
+			delete(repo.keys, row.MaterialID)
+		default:
+			continue
+		}
+		if err == nil && row.Action != AuditDelete {
+			repo.keys[row.MaterialID] = row.Key
+			if row.check != nil {
+				_, err = repo.materials.AddRotorBarCheck(ctx, *row.check, change)
+			}
+		}
+		if err != nil {
+			return report, fmt.Errorf("unable to import row %d: %w", row.Row, err)
+		}
+	}
+
+	report.Committed = true
+	return report, nil
+}
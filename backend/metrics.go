@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// metricsPath is scraped by Prometheus. It sits outside /api, so nginx never exposes it,
+// and only holds aggregates, so it needs no session token.
+const metricsPath = "/metrics"
+
+// latencyBuckets are the upper bounds in seconds of every latency histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	httpRequestDuration = newHistogramVec("electra_http_request_duration_seconds", "Latency of HTTP requests by route pattern.", "method", "route", "status")
+	dbQueryDuration     = newHistogramVec("electra_db_query_duration_seconds", "Duration of database queries by statement, including reading their rows.", "statement", "outcome")
+)
+
+// histogram counts observations per bucket, counts[i] holds those at most latencyBuckets[i]
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogramVec is a histogram for every combination of label values
+type histogramVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*histogram
+	values map[string][]string
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, series: map[string]*histogram{}, values: map[string][]string{}}
+}
+
+// Function to record one observation for the label values, given in the order of the labels
+func (h *histogramVec) observe(seconds float64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	series := h.series[key]
+	if series == nil {
+		series = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		h.series[key] = series
+		h.values[key] = values
+	}
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += seconds
+}
+
+// Function to write every series in the Prometheus text format, ordered by label values
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series, labels := h.series[key], formatLabels(h.labels, h.values[key])
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", h.name, labels, formatFloat(bound), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, labels, series.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, labels, formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, labels, series.count)
+	}
+}
+
+// Function to write a single gauge or counter without labels
+func writeMetric(w io.Writer, name, kind, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatFloat(value))
+}
+
+// Function to format label pairs, escaping values as the text format requires
+func formatLabels(names, values []string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escaper.Replace(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Function to serve the metrics, the pool statistics are read at every scrape
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	httpRequestDuration.write(w)
+	dbQueryDuration.write(w)
+
+	// Waiting for a connection shows up as acquire duration and empty acquires, the first signs of a saturated pool
+	stat := db.Stat()
+	writeMetric(w, "electra_db_pool_acquired_conns", "gauge", "Connections currently in use.", float64(stat.AcquiredConns()))
+	writeMetric(w, "electra_db_pool_idle_conns", "gauge", "Connections currently idle.", float64(stat.IdleConns()))
+	writeMetric(w, "electra_db_pool_constructing_conns", "gauge", "Connections currently being opened.", float64(stat.ConstructingConns()))
+	writeMetric(w, "electra_db_pool_total_conns", "gauge", "Connections currently open.", float64(stat.TotalConns()))
+	writeMetric(w, "electra_db_pool_max_conns", "gauge", "Most connections the pool opens, DB_MAX_CONNS.", float64(stat.MaxConns()))
+	writeMetric(w, "electra_db_pool_acquires_total", "counter", "Connections acquired from the pool.", float64(stat.AcquireCount()))
+	writeMetric(w, "electra_db_pool_acquire_duration_seconds_total", "counter", "Time spent acquiring connections.", stat.AcquireDuration().Seconds())
+	writeMetric(w, "electra_db_pool_empty_acquires_total", "counter", "Acquires that waited because no connection was idle.", float64(stat.EmptyAcquireCount()))
+	writeMetric(w, "electra_db_pool_canceled_acquires_total", "counter", "Acquires canceled before a connection was free.", float64(stat.CanceledAcquireCount()))
+	writeMetric(w, "electra_db_pool_new_conns_total", "counter", "Connections opened.", float64(stat.NewConnsCount()))
+	writeMetric(w, "electra_db_pool_max_lifetime_destroys_total", "counter", "Connections closed after DB_MAX_CONN_LIFETIME.", float64(stat.MaxLifetimeDestroyCount()))
+	writeMetric(w, "electra_db_pool_max_idle_destroys_total", "counter", "Connections closed after idling too long.", float64(stat.MaxIdleDestroyCount()))
+}
+
+// requestStats is what one request did, filled in while it runs and logged when it ends
+type requestStats struct {
+	ID   string
+	User string
+	// rows, queries and dbNanos are updated atomically by queryTracer
+	rows    int64
+	queries int64
+	dbNanos int64
+}
+
+type requestStatsKey struct{}
+
+// Function to return the stats of the request ctx belongs to, nil outside a request
+func requestStatsFrom(ctx context.Context) *requestStats {
+	stats, _ := ctx.Value(requestStatsKey{}).(*requestStats)
+	return stats
+}
+
+// statusRecorder remembers the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	n, err := recorder.ResponseWriter.Write(data)
+	recorder.bytes += int64(n)
+	return n, err
+}
+
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Function to wrap the server handler so every request gets a request ID, a latency observation and a log line.
+// routes labels the request with its registered pattern, never the raw path, so material IDs do not multiply the series.
+func observeRequests(routes *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// A request ID from nginx or the client is kept, so one request can be followed across services
+		stats := &requestStats{ID: r.Header.Get("X-Request-ID")}
+		if !validRequestID(stats.ID) {
+			stats.ID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", stats.ID)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestStatsKey{}, stats)))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		elapsed := time.Since(start)
+		_, route := routes.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.observe(elapsed.Seconds(), r.Method, route, strconv.Itoa(recorder.status))
+
+		level := levelInfo
+		switch {
+		case recorder.status >= http.StatusInternalServerError:
+			level = levelError
+		case route == metricsPath || route == healthzPath || route == readyzPath:
+			// Scrapes and probes arrive every few seconds and would drown the requests of users
+			level = levelDebug
+		}
+		logEvent(level, "request", map[string]interface{}{
+			"request_id":  stats.ID,
+			"method":      r.Method,
+			"route":       route,
+			"path":        r.URL.Path,
+			"status":      recorder.status,
+			"duration_ms": float64(elapsed.Microseconds()) / 1000,
+			"bytes":       recorder.bytes,
+			"rows":        atomic.LoadInt64(&stats.rows),
+			"queries":     atomic.LoadInt64(&stats.queries),
+			"db_ms":       float64(atomic.LoadInt64(&stats.dbNanos)/1000) / 1000,
+			"user":        stats.User,
+		})
+	})
+}
+
+// Function to accept a request ID of up to 64 letters, digits, dashes and underscores
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Function to generate a random request ID of 16 hex characters
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(id)
+}
+
+// queryTracer times every query for dbQueryDuration and adds its rows to the stats of the request running it
+type queryTracer struct{}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	at        time.Time
+	statement string
+}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{at: time.Now(), statement: statementKind(data.SQL)})
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start.at)
+
+	outcome := "ok"
+	if data.Err != nil {
+		outcome = "error"
+	}
+	dbQueryDuration.observe(elapsed.Seconds(), start.statement, outcome)
+
+	if stats := requestStatsFrom(ctx); stats != nil {
+		atomic.AddInt64(&stats.queries, 1)
+		atomic.AddInt64(&stats.dbNanos, int64(elapsed))
+		if data.Err == nil {
+			atomic.AddInt64(&stats.rows, data.CommandTag.RowsAffected())
+		}
+	}
+}
+
+// Function to label a query by its first keyword, the SQL itself is never logged or exported
+func statementKind(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "other"
+	}
+	switch keyword := strings.ToLower(fields[0]); keyword {
+	case "select", "insert", "update", "delete", "with":
+		return keyword
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrationFiles holds NNNN_name.up.sql and NNNN_name.down.sql for every schema version
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the advisory lock key held while a migration runs, so two instances never apply one twice
+const migrationLock = 7351001
+
+// Migration is one version of the schema, Down undoes Up
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when it was applied, AppliedAt is nil while it is pending
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Function to read the embedded migrations in version order
+func loadMigrations() ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		base := path.Base(file)
+		name, direction := strings.TrimSuffix(base, ".sql"), ""
+		switch {
+		case strings.HasSuffix(name, ".up"):
+			name, direction = strings.TrimSuffix(name, ".up"), "up"
+		case strings.HasSuffix(name, ".down"):
+			name, direction = strings.TrimSuffix(name, ".down"), "down"
+		default:
+			return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", base)
+		}
+		number, title, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s must be named NNNN_name", base)
+		}
+
+		content, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: title}
+			byVersion[version] = migration
+		}
+		if migration.Name != title {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, title)
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d %s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Function to create the table recording applied migrations
+func ensureMigrationsTable(ctx context.Context, db querier) error {
+	_, err := db.Exec(ctx, `CREATE TABLE IF NOT EXISTS public.schema_migrations (
+		version integer PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("unable to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// Function to list every embedded migration and when it was applied
+func migrationStatus(ctx context.Context, db querier) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationsTable(ctx, db); err != nil {
+		return nil, err
+	}
+	applied, err := selectAppliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = MigrationStatus{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// Function to read when every applied migration was applied, by version
+func selectAppliedMigrations(ctx context.Context, db querier) (map[int]time.Time, error) {
+	rows, err := db.Query(ctx, "SELECT version, applied_at FROM public.schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("unable to select schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	return applied, nil
+}
+
+// Function to count the migrations not applied yet
+func pendingMigrations(ctx context.Context, db querier) (int, error) {
+	statuses, err := migrationStatus(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// Function to apply up to steps pending migrations in version order, every pending one when steps is 0
+func migrateUp(ctx context.Context, db *pgxpool.Pool, steps int) ([]Migration, error) {
+	statuses, err := migrationStatus(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			continue
+		}
+		if steps > 0 && len(applied) == steps {
+			break
+		}
+		if err := runMigration(ctx, db, status.Migration, true); err != nil {
+			return applied, err
+		}
+		applied = append(applied, status.Migration)
+	}
+	return applied, nil
+}
+
+// Function to undo the last steps applied migrations, newest first
+func migrateDown(ctx context.Context, db *pgxpool.Pool, steps int) ([]Migration, error) {
+	statuses, err := migrationStatus(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(statuses) - 1; i >= 0 && len(reverted) < steps; i-- {
+		if statuses[i].AppliedAt == nil {
+			continue
+		}
+		if err := runMigration(ctx, db, statuses[i].Migration, false); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, statuses[i].Migration)
+	}
+	return reverted, nil
+}
+
+// Function to apply or undo one migration and record it, in a single transaction.
+// Another instance that got there first makes this a no-op.
+func runMigration(ctx context.Context, db *pgxpool.Pool, migration Migration, up bool) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+			return fmt.Errorf("unable to lock schema_migrations: %w", err)
+		}
+
+		var applied bool
+		err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM public.schema_migrations WHERE version = $1)", migration.Version).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("unable to select schema_migrations: %w", err)
+		}
+		if applied == up {
+			return nil
+		}
+
+		// Without arguments the statements of a file are sent together and run in order
+		if up {
+			if _, err := tx.Exec(ctx, migration.Up); err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			_, err = tx.Exec(ctx, "INSERT INTO public.schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+		} else {
+			if _, err := tx.Exec(ctx, migration.Down); err != nil {
+				return fmt.Errorf("reverting migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			_, err = tx.Exec(ctx, "DELETE FROM public.schema_migrations WHERE version = $1", migration.Version)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to record migration %d: %w", migration.Version, err)
+		}
+		return nil
+	})
+}
+
+// Function to run "migrate up [N]", "migrate down [N]" or "migrate status".
+// up applies every pending migration unless N is given, down reverts the last one unless N is given.
+func runMigrateCommand(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	command, steps := flags.Arg(0), 0
+	if flags.NArg() > 2 {
+		return errors.New("migrate: usage is migrate up [N], migrate down [N] or migrate status")
+	}
+	if flags.NArg() == 2 {
+		n, err := strconv.Atoi(flags.Arg(1))
+		if err != nil || n <= 0 {
+			return fmt.Errorf("migrate: %q is not a number of migrations", flags.Arg(1))
+		}
+		steps = n
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrateUp(ctx, db, steps)
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d %s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Nothing to apply, the schema is up to date")
+		}
+		return err
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		reverted, err := migrateDown(ctx, db, steps)
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %04d %s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Println("Nothing to revert, no migration is applied")
+		}
+		return err
+	case "status":
+		if flags.NArg() != 1 {
+			return errors.New("migrate: status takes no arguments")
+		}
+		statuses, err := migrationStatus(ctx, db)
+		if err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return writer.Flush()
+	default:
+		return errors.New("migrate: usage is migrate up [N], migrate down [N] or migrate status")
+	}
+}
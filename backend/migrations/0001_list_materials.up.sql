@@ -0,0 +1,25 @@
+-- The motor list as first imported from the spreadsheet. Databases created before migrations
+-- already hold it, so it is only created when missing and later migrations are safe to re-run.
+CREATE TABLE IF NOT EXISTS public.list_materials (
+	id integer PRIMARY KEY,
+	qcode text NOT NULL DEFAULT '',
+	plant text NOT NULL,
+	area text NOT NULL,
+	category text NOT NULL DEFAULT 'HV Motor',
+	name text NOT NULL,
+	capacity integer NOT NULL DEFAULT 0,
+	voltage integer NOT NULL DEFAULT 0,
+	current integer NOT NULL DEFAULT 0,
+	rpm integer NOT NULL DEFAULT 0,
+	shaft_diameter integer NOT NULL DEFAULT 0,
+	base_width integer NOT NULL DEFAULT 0,
+	base_length integer NOT NULL DEFAULT 0,
+	c integer NOT NULL DEFAULT 0,
+	e integer NOT NULL DEFAULT 0,
+	h integer NOT NULL DEFAULT 0,
+	maker text NOT NULL DEFAULT '',
+	installed_qty smallint NOT NULL DEFAULT 0,
+	standby_qty smallint NOT NULL DEFAULT 0,
+	spare_qty smallint NOT NULL DEFAULT 0,
+	frame integer NOT NULL DEFAULT 0
+);
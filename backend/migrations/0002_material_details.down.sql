@@ -0,0 +1,20 @@
+DROP INDEX IF EXISTS public.list_materials_category_idx;
+
+ALTER TABLE public.list_materials
+	DROP COLUMN IF EXISTS specs,
+	DROP COLUMN IF EXISTS starting_current_frequency,
+	DROP COLUMN IF EXISTS starting_current_last_check,
+	DROP COLUMN IF EXISTS starting_current_last_start,
+	DROP COLUMN IF EXISTS starting_current_last_overhaul,
+	DROP COLUMN IF EXISTS serial_number,
+	DROP COLUMN IF EXISTS type,
+	DROP COLUMN IF EXISTS starting_current_when,
+	DROP COLUMN IF EXISTS starting_current_check,
+	DROP COLUMN IF EXISTS rotor_bar_check_date,
+	DROP COLUMN IF EXISTS rotor_bar_check_status,
+	DROP COLUMN IF EXISTS rotor_bar_reason,
+	DROP COLUMN IF EXISTS rotor_bar_remark,
+	DROP COLUMN IF EXISTS operation,
+	DROP COLUMN IF EXISTS remark,
+	DROP COLUMN IF EXISTS updated_at,
+	DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,38 @@
+-- Columns added to the spreadsheet layout of list_materials after it was first imported
+
+ALTER TABLE public.list_materials ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now();
+
+ALTER TABLE public.list_materials ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT now();
+
+ALTER TABLE public.list_materials
+	ADD COLUMN IF NOT EXISTS serial_number text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS type text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS starting_current_when text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS starting_current_check text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS rotor_bar_check_date date,
+	ADD COLUMN IF NOT EXISTS rotor_bar_check_status text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS rotor_bar_reason text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS rotor_bar_remark text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS operation text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS remark text NOT NULL DEFAULT '';
+
+-- The structured schedule is seeded once from the free-text "When ?" column of the spreadsheet
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = 'public' AND table_name = 'list_materials' AND column_name = 'starting_current_frequency') THEN
+		ALTER TABLE public.list_materials
+			ADD COLUMN starting_current_frequency text NOT NULL DEFAULT '',
+			ADD COLUMN starting_current_last_check date,
+			ADD COLUMN starting_current_last_start date,
+			ADD COLUMN starting_current_last_overhaul date;
+		UPDATE public.list_materials SET starting_current_frequency = CASE upper(trim(starting_current_when))
+			WHEN 'EVERY TIME' THEN 'every_start'
+			WHEN 'NOT EVERY TIME' THEN 'overhaul'
+			ELSE '' END;
+	END IF;
+END $$;
+
+ALTER TABLE public.list_materials ADD COLUMN IF NOT EXISTS specs jsonb NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS list_materials_category_idx ON public.list_materials (category);
@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS rotor_bar_checks_touch_material ON public.rotor_bar_checks;
+
+DROP TRIGGER IF EXISTS list_materials_rotor_bar_status ON public.list_materials;
+
+DROP TABLE IF EXISTS public.rotor_bar_checks;
+
+DROP FUNCTION IF EXISTS public.touch_rotor_bar_material();
+
+DROP FUNCTION IF EXISTS public.derive_rotor_bar_status();
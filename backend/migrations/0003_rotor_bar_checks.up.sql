@@ -0,0 +1,53 @@
+-- Rotor bar inspection history, the rotor_bar_* columns of a material follow its latest check
+
+CREATE TABLE IF NOT EXISTS public.rotor_bar_checks (
+	id bigserial PRIMARY KEY,
+	material_id integer NOT NULL REFERENCES public.list_materials (id) ON DELETE CASCADE,
+	check_date date NOT NULL,
+	status text NOT NULL,
+	inspector text NOT NULL,
+	reason text NOT NULL DEFAULT '',
+	findings text NOT NULL DEFAULT '',
+	attachments text[] NOT NULL DEFAULT '{}',
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS rotor_bar_checks_latest_idx ON public.rotor_bar_checks (material_id, check_date DESC, id DESC);
+
+-- The rotor_bar_* columns of a material always mirror its latest check, whoever writes the row
+CREATE OR REPLACE FUNCTION public.derive_rotor_bar_status() RETURNS trigger AS $$
+DECLARE
+	latest record;
+BEGIN
+	SELECT check_date, status, reason, findings INTO latest
+	FROM public.rotor_bar_checks WHERE material_id = NEW.id
+	ORDER BY check_date DESC, id DESC LIMIT 1;
+	IF FOUND THEN
+		NEW.rotor_bar_check_date := latest.check_date;
+		NEW.rotor_bar_check_status := latest.status;
+		NEW.rotor_bar_reason := latest.reason;
+		NEW.rotor_bar_remark := latest.findings;
+	END IF;
+	RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS list_materials_rotor_bar_status ON public.list_materials;
+
+CREATE TRIGGER list_materials_rotor_bar_status BEFORE INSERT OR UPDATE ON public.list_materials
+	FOR EACH ROW EXECUTE FUNCTION public.derive_rotor_bar_status();
+
+-- Touching the material re-runs derive_rotor_bar_status after a check changes
+CREATE OR REPLACE FUNCTION public.touch_rotor_bar_material() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		UPDATE public.list_materials SET updated_at = now() WHERE id = OLD.material_id;
+	ELSE
+		UPDATE public.list_materials SET updated_at = now() WHERE id = NEW.material_id;
+	END IF;
+	RETURN NULL;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS rotor_bar_checks_touch_material ON public.rotor_bar_checks;
+
+CREATE TRIGGER rotor_bar_checks_touch_material AFTER INSERT OR UPDATE OR DELETE ON public.rotor_bar_checks
+	FOR EACH ROW EXECUTE FUNCTION public.touch_rotor_bar_material();
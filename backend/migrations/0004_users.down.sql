@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.user_roles;
+
+DROP TABLE IF EXISTS public.users;
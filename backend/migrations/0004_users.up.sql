@@ -0,0 +1,16 @@
+-- Local users and the role each one holds per plant, '*' is every plant
+CREATE TABLE IF NOT EXISTS public.users (
+	id bigserial PRIMARY KEY,
+	username text NOT NULL UNIQUE,
+	password_hash text NOT NULL,
+	display_name text NOT NULL DEFAULT '',
+	disabled boolean NOT NULL DEFAULT false,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS public.user_roles (
+	user_id bigint NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+	plant text NOT NULL,
+	role text NOT NULL CHECK (role IN ('viewer', 'planner', 'maintenance-admin')),
+	PRIMARY KEY (user_id, plant)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE public.list_materials
+	DROP COLUMN IF EXISTS pic_team,
+	DROP COLUMN IF EXISTS pic_name,
+	DROP COLUMN IF EXISTS pic_phone,
+	DROP COLUMN IF EXISTS pic_email;
@@ -0,0 +1,6 @@
+-- The person in charge was accepted by the API but never stored
+ALTER TABLE public.list_materials
+	ADD COLUMN IF NOT EXISTS pic_team text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS pic_name text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS pic_phone text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS pic_email text NOT NULL DEFAULT '';
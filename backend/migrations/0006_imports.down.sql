@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS public.import_profiles;
+
+DROP INDEX IF EXISTS public.list_materials_import_key_idx;
+
+ALTER TABLE public.list_materials DROP COLUMN IF EXISTS import_key;
@@ -0,0 +1,13 @@
+-- Spreadsheet imports
+
+-- The natural key the importers match rows on, see assignImportKeys
+ALTER TABLE public.list_materials ADD COLUMN IF NOT EXISTS import_key text;
+
+CREATE UNIQUE INDEX IF NOT EXISTS list_materials_import_key_idx ON public.list_materials (import_key);
+
+CREATE TABLE IF NOT EXISTS public.import_profiles (
+	name text PRIMARY KEY,
+	mapping jsonb NOT NULL,
+	updated_by text NOT NULL DEFAULT '',
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
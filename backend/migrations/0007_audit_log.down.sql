@@ -0,0 +1,8 @@
+-- The append-only triggers would reject dropping the rows, they go first
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON public.audit_log;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON public.audit_log;
+
+DROP TABLE IF EXISTS public.audit_log;
+
+DROP FUNCTION IF EXISTS public.reject_audit_log_change();
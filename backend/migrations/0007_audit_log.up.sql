@@ -0,0 +1,30 @@
+-- Audit log of material changes, material_id has no foreign key so history outlives deletes
+CREATE TABLE IF NOT EXISTS public.audit_log (
+	id bigserial PRIMARY KEY,
+	occurred_at timestamptz NOT NULL DEFAULT now(),
+	actor text NOT NULL,
+	endpoint text NOT NULL,
+	action text NOT NULL,
+	material_id integer,
+	changes jsonb NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_material_idx ON public.audit_log (material_id, id DESC);
+
+CREATE INDEX IF NOT EXISTS audit_log_occurred_at_idx ON public.audit_log (occurred_at, id);
+
+-- The audit log is append-only, rows can never be changed or removed
+CREATE OR REPLACE FUNCTION public.reject_audit_log_change() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON public.audit_log;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON public.audit_log
+	FOR EACH ROW EXECUTE FUNCTION public.reject_audit_log_change();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON public.audit_log;
+
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON public.audit_log
+	FOR EACH STATEMENT EXECUTE FUNCTION public.reject_audit_log_change();
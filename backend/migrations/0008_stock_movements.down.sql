@@ -0,0 +1,10 @@
+-- The quantity columns keep the last derived balances
+DROP TRIGGER IF EXISTS list_materials_stock_balances ON public.list_materials;
+
+DROP TABLE IF EXISTS public.stock_movements;
+
+DROP FUNCTION IF EXISTS public.derive_stock_balances();
+
+DROP FUNCTION IF EXISTS public.check_stock_movement();
+
+DROP FUNCTION IF EXISTS public.stock_balance(integer, text);
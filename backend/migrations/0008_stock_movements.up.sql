@@ -0,0 +1,80 @@
+-- Stock ledger, installed_qty, standby_qty and spare_qty are derived from it
+
+-- The stock ledger is seeded once with the quantities stored before it existed
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM information_schema.tables
+		WHERE table_schema = 'public' AND table_name = 'stock_movements') THEN
+		CREATE TABLE public.stock_movements (
+			id bigserial PRIMARY KEY,
+			material_id integer NOT NULL REFERENCES public.list_materials (id) ON DELETE CASCADE,
+			movement text NOT NULL,
+			from_location text NOT NULL,
+			to_location text NOT NULL,
+			quantity integer NOT NULL CHECK (quantity > 0),
+			reason text NOT NULL DEFAULT '',
+			reference text NOT NULL DEFAULT '',
+			actor text NOT NULL DEFAULT '',
+			moved_at timestamptz NOT NULL DEFAULT now()
+		);
+		CREATE INDEX stock_movements_material_idx ON public.stock_movements (material_id, id);
+		INSERT INTO public.stock_movements (material_id, movement, from_location, to_location, quantity, reason, actor)
+		SELECT id, 'stocktake', 'stocktake', q.location, q.quantity, 'Opening balance', 'system'
+		FROM public.list_materials,
+			LATERAL (VALUES ('installed', installed_qty), ('standby', standby_qty), ('spare', spare_qty)) AS q (location, quantity)
+		WHERE q.quantity > 0;
+	END IF;
+END $$;
+
+CREATE OR REPLACE FUNCTION public.stock_balance(material integer, location text) RETURNS bigint AS $$
+	SELECT COALESCE(sum(CASE WHEN to_location = location THEN quantity ELSE -quantity END), 0)
+	FROM public.stock_movements
+	WHERE material_id = material AND (to_location = location OR from_location = location)
+$$ LANGUAGE sql STABLE;
+
+-- A movement may not take more out of a location than it holds, supplier, scrap and stocktake are unbounded
+CREATE OR REPLACE FUNCTION public.check_stock_movement() RETURNS trigger AS $$
+DECLARE
+	available bigint;
+BEGIN
+	-- Locking the material serialises concurrent movements of the same motor
+	PERFORM 1 FROM public.list_materials WHERE id = NEW.material_id FOR UPDATE;
+	IF NEW.from_location IN ('installed', 'standby', 'spare', 'workshop', 'rewind') THEN
+		available := public.stock_balance(NEW.material_id, NEW.from_location);
+		IF available < NEW.quantity THEN
+			RAISE EXCEPTION '% holds %, cannot move %', NEW.from_location, available, NEW.quantity
+				USING ERRCODE = 'check_violation';
+		END IF;
+	END IF;
+	RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS stock_movements_check ON public.stock_movements;
+
+CREATE TRIGGER stock_movements_check BEFORE INSERT ON public.stock_movements
+	FOR EACH ROW EXECUTE FUNCTION public.check_stock_movement();
+
+-- Movements are append-only like the audit log, a mistake is corrected by a movement back
+DROP TRIGGER IF EXISTS stock_movements_append_only ON public.stock_movements;
+
+CREATE TRIGGER stock_movements_append_only BEFORE UPDATE ON public.stock_movements
+	FOR EACH ROW EXECUTE FUNCTION public.reject_audit_log_change();
+
+-- The quantity columns of a material always equal its ledger balances, whoever writes the row
+CREATE OR REPLACE FUNCTION public.derive_stock_balances() RETURNS trigger AS $$
+BEGIN
+	NEW.installed_qty := public.stock_balance(NEW.id, 'installed');
+	NEW.standby_qty := public.stock_balance(NEW.id, 'standby');
+	NEW.spare_qty := public.stock_balance(NEW.id, 'spare');
+	RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS list_materials_stock_balances ON public.list_materials;
+
+CREATE TRIGGER list_materials_stock_balances BEFORE INSERT OR UPDATE ON public.list_materials
+	FOR EACH ROW EXECUTE FUNCTION public.derive_stock_balances();
+
+DROP TRIGGER IF EXISTS stock_movements_touch_material ON public.stock_movements;
+
+CREATE TRIGGER stock_movements_touch_material AFTER INSERT ON public.stock_movements
+	FOR EACH ROW EXECUTE FUNCTION public.touch_rotor_bar_material();
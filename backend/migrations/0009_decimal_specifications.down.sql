@@ -0,0 +1,12 @@
+-- Decimals are rounded to the nearest whole unit
+ALTER TABLE public.list_materials
+	ALTER COLUMN capacity TYPE integer USING round(capacity)::integer,
+	ALTER COLUMN voltage TYPE integer USING round(voltage)::integer,
+	ALTER COLUMN current TYPE integer USING round(current)::integer,
+	ALTER COLUMN rpm TYPE integer USING round(rpm)::integer,
+	ALTER COLUMN shaft_diameter TYPE integer USING round(shaft_diameter)::integer,
+	ALTER COLUMN base_width TYPE integer USING round(base_width)::integer,
+	ALTER COLUMN base_length TYPE integer USING round(base_length)::integer,
+	ALTER COLUMN c TYPE integer USING round(c)::integer,
+	ALTER COLUMN e TYPE integer USING round(e)::integer,
+	ALTER COLUMN h TYPE integer USING round(h)::integer;
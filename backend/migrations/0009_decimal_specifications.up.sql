@@ -0,0 +1,12 @@
+-- Specifications and sizes hold decimals such as 6.6 kV or 0.75 kW, in the units of categories
+DO $$
+DECLARE
+	spec text;
+BEGIN
+	FOREACH spec IN ARRAY ARRAY['capacity', 'voltage', 'current', 'rpm', 'shaft_diameter', 'base_width', 'base_length', 'c', 'e', 'h'] LOOP
+		IF EXISTS (SELECT 1 FROM information_schema.columns
+			WHERE table_schema = 'public' AND table_name = 'list_materials' AND column_name = spec AND data_type <> 'numeric') THEN
+			EXECUTE format('ALTER TABLE public.list_materials ALTER COLUMN %I TYPE numeric USING %I::numeric', spec, spec);
+		END IF;
+	END LOOP;
+END $$;
@@ -0,0 +1,10 @@
+-- pg_trgm is left installed, other objects of the database may use it
+DROP INDEX IF EXISTS public.list_materials_serial_number_trgm_idx;
+
+DROP INDEX IF EXISTS public.list_materials_name_trgm_idx;
+
+DROP INDEX IF EXISTS public.list_materials_search_idx;
+
+ALTER TABLE public.list_materials DROP COLUMN IF EXISTS search_document;
+
+DROP FUNCTION IF EXISTS public.search_key(text);
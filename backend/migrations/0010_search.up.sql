@@ -0,0 +1,19 @@
+-- q= searches names, serials, makers and remarks, tags are also compared without their punctuation
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE OR REPLACE FUNCTION public.search_key(value text) RETURNS text AS $$
+	SELECT lower(regexp_replace(coalesce(value, ''), '[^[:alnum:]]+', '', 'g'))
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+ALTER TABLE public.list_materials ADD COLUMN IF NOT EXISTS search_document tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', coalesce(name, '') || ' ' || public.search_key(name)), 'A') ||
+	setweight(to_tsvector('simple', coalesce(serial_number, '') || ' ' || public.search_key(serial_number)), 'A') ||
+	setweight(to_tsvector('simple', coalesce(maker, '')), 'B') ||
+	setweight(to_tsvector('simple', coalesce(remark, '') || ' ' || coalesce(operation, '') || ' ' || coalesce(rotor_bar_remark, '')), 'C')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS list_materials_search_idx ON public.list_materials USING gin (search_document);
+
+CREATE INDEX IF NOT EXISTS list_materials_name_trgm_idx ON public.list_materials USING gin (public.search_key(name) gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS list_materials_serial_number_trgm_idx ON public.list_materials USING gin (public.search_key(serial_number) gin_trgm_ops);
@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS public.list_materials_category_updated_at_idx;
+
+DROP INDEX IF EXISTS public.list_materials_category_rpm_idx;
+
+DROP INDEX IF EXISTS public.list_materials_category_voltage_idx;
+
+DROP INDEX IF EXISTS public.list_materials_category_capacity_idx;
+
+DROP INDEX IF EXISTS public.list_materials_category_name_idx;
+
+DROP INDEX IF EXISTS public.list_materials_category_plant_name_idx;
+
+DROP INDEX IF EXISTS public.list_materials_category_id_idx;
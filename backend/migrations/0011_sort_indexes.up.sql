@@ -0,0 +1,14 @@
+-- Lists always filter on category, so these serve ?sort= on the common keys without sorting in memory
+CREATE INDEX IF NOT EXISTS list_materials_category_id_idx ON public.list_materials (category, id);
+
+CREATE INDEX IF NOT EXISTS list_materials_category_plant_name_idx ON public.list_materials (category, plant, name, id);
+
+CREATE INDEX IF NOT EXISTS list_materials_category_name_idx ON public.list_materials (category, name, id);
+
+CREATE INDEX IF NOT EXISTS list_materials_category_capacity_idx ON public.list_materials (category, capacity, id);
+
+CREATE INDEX IF NOT EXISTS list_materials_category_voltage_idx ON public.list_materials (category, voltage, id);
+
+CREATE INDEX IF NOT EXISTS list_materials_category_rpm_idx ON public.list_materials (category, rpm, id);
+
+CREATE INDEX IF NOT EXISTS list_materials_category_updated_at_idx ON public.list_materials (category, updated_at, id);
@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS starting_current_events_touch_material ON public.starting_current_events;
+
+DROP TRIGGER IF EXISTS list_materials_starting_current_dates ON public.list_materials;
+
+DROP TABLE IF EXISTS public.starting_current_events;
+
+DROP FUNCTION IF EXISTS public.touch_starting_current_material();
+
+DROP FUNCTION IF EXISTS public.derive_starting_current_dates();
@@ -0,0 +1,48 @@
+-- Starting current checks, starts and overhauls of a motor, the starting_current_last_* columns follow the latest of each
+
+CREATE TABLE IF NOT EXISTS public.starting_current_events (
+	id bigserial PRIMARY KEY,
+	material_id integer NOT NULL REFERENCES public.list_materials (id) ON DELETE CASCADE,
+	event text NOT NULL CHECK (event IN ('check', 'start', 'overhaul')),
+	event_date date NOT NULL,
+	-- measured_current is the starting current in A a check measured, NULL for starts and overhauls
+	measured_current numeric(12, 3) CHECK (measured_current IS NULL OR (event = 'check' AND measured_current >= 0)),
+	recorded_by text NOT NULL,
+	remark text NOT NULL DEFAULT '',
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS starting_current_events_latest_idx ON public.starting_current_events (material_id, event, event_date DESC, id DESC);
+
+-- A material with events of a kind always shows the latest of them, one without keeps the date it was given
+CREATE OR REPLACE FUNCTION public.derive_starting_current_dates() RETURNS trigger AS $$
+BEGIN
+	NEW.starting_current_last_check := COALESCE((SELECT max(event_date) FROM public.starting_current_events
+		WHERE material_id = NEW.id AND event = 'check'), NEW.starting_current_last_check);
+	NEW.starting_current_last_start := COALESCE((SELECT max(event_date) FROM public.starting_current_events
+		WHERE material_id = NEW.id AND event = 'start'), NEW.starting_current_last_start);
+	NEW.starting_current_last_overhaul := COALESCE((SELECT max(event_date) FROM public.starting_current_events
+		WHERE material_id = NEW.id AND event = 'overhaul'), NEW.starting_current_last_overhaul);
+	RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS list_materials_starting_current_dates ON public.list_materials;
+
+CREATE TRIGGER list_materials_starting_current_dates BEFORE INSERT OR UPDATE ON public.list_materials
+	FOR EACH ROW EXECUTE FUNCTION public.derive_starting_current_dates();
+
+-- Touching the material re-runs derive_starting_current_dates after an event changes
+CREATE OR REPLACE FUNCTION public.touch_starting_current_material() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		UPDATE public.list_materials SET updated_at = now() WHERE id = OLD.material_id;
+	ELSE
+		UPDATE public.list_materials SET updated_at = now() WHERE id = NEW.material_id;
+	END IF;
+	RETURN NULL;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS starting_current_events_touch_material ON public.starting_current_events;
+
+CREATE TRIGGER starting_current_events_touch_material AFTER INSERT OR UPDATE OR DELETE ON public.starting_current_events
+	FOR EACH ROW EXECUTE FUNCTION public.touch_starting_current_material();
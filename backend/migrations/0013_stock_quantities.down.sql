@@ -0,0 +1,7 @@
+ALTER TABLE public.stock_movements DROP CONSTRAINT IF EXISTS stock_movements_quantity_max;
+
+-- A balance above the smallint range is capped rather than failing the rollback, stock_movements still holds the true balance
+ALTER TABLE public.list_materials
+	ALTER COLUMN installed_qty TYPE smallint USING LEAST(installed_qty, 32767),
+	ALTER COLUMN standby_qty TYPE smallint USING LEAST(standby_qty, 32767),
+	ALTER COLUMN spare_qty TYPE smallint USING LEAST(spare_qty, 32767);
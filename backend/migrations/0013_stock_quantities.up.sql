@@ -0,0 +1,10 @@
+-- The quantity columns hold ledger balances, which outgrow smallint long before a movement is rejected
+ALTER TABLE public.list_materials
+	ALTER COLUMN installed_qty TYPE integer,
+	ALTER COLUMN standby_qty TYPE integer,
+	ALTER COLUMN spare_qty TYPE integer;
+
+-- A single movement is bounded like maxStockQuantity, so a typo cannot book a million motors
+ALTER TABLE public.stock_movements DROP CONSTRAINT IF EXISTS stock_movements_quantity_max;
+
+ALTER TABLE public.stock_movements ADD CONSTRAINT stock_movements_quantity_max CHECK (quantity <= 10000);
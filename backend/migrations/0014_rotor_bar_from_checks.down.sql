@@ -0,0 +1,16 @@
+-- The backfilled checks and cleared statuses stay, a material without checks keeps whatever is written again
+CREATE OR REPLACE FUNCTION public.derive_rotor_bar_status() RETURNS trigger AS $$
+DECLARE
+	latest record;
+BEGIN
+	SELECT check_date, status, reason, findings INTO latest
+	FROM public.rotor_bar_checks WHERE material_id = NEW.id
+	ORDER BY check_date DESC, id DESC LIMIT 1;
+	IF FOUND THEN
+		NEW.rotor_bar_check_date := latest.check_date;
+		NEW.rotor_bar_check_status := latest.status;
+		NEW.rotor_bar_reason := latest.reason;
+		NEW.rotor_bar_remark := latest.findings;
+	END IF;
+	RETURN NEW;
+END $$ LANGUAGE plpgsql;
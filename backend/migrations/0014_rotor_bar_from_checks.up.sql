@@ -0,0 +1,45 @@
+-- The rotor_bar_* columns are only derived from rotor_bar_checks, material writes no longer set them
+
+-- A dated status written before the checks existed becomes the first check of its motor
+INSERT INTO public.rotor_bar_checks (material_id, check_date, status, inspector, reason, findings)
+SELECT m.id, m.rotor_bar_check_date, upper(trim(m.rotor_bar_check_status)), 'system', m.rotor_bar_reason, m.rotor_bar_remark
+FROM public.list_materials m
+WHERE m.rotor_bar_check_date IS NOT NULL AND trim(m.rotor_bar_check_status) <> ''
+	AND NOT EXISTS (SELECT 1 FROM public.rotor_bar_checks c WHERE c.material_id = m.id);
+
+-- A material without checks has no rotor bar status
+CREATE OR REPLACE FUNCTION public.derive_rotor_bar_status() RETURNS trigger AS $$
+DECLARE
+	latest record;
+BEGIN
+	SELECT check_date, status, reason, findings INTO latest
+	FROM public.rotor_bar_checks WHERE material_id = NEW.id
+	ORDER BY check_date DESC, id DESC LIMIT 1;
+	IF FOUND THEN
+		NEW.rotor_bar_check_date := latest.check_date;
+		NEW.rotor_bar_check_status := latest.status;
+		NEW.rotor_bar_reason := latest.reason;
+		NEW.rotor_bar_remark := latest.findings;
+	ELSE
+		NEW.rotor_bar_check_date := NULL;
+		NEW.rotor_bar_check_status := '';
+		NEW.rotor_bar_reason := '';
+		NEW.rotor_bar_remark := '';
+	END IF;
+	RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+-- Undated statuses cannot become checks, the audit log keeps what is cleared
+INSERT INTO public.audit_log (actor, endpoint, action, material_id, changes)
+SELECT 'system', 'migration 0014 rotor_bar_from_checks', 'update', m.id, jsonb_build_array(
+	jsonb_build_object('field', 'rotor_bar_check_date', 'before', m.rotor_bar_check_date, 'after', NULL),
+	jsonb_build_object('field', 'rotor_bar_check_status', 'before', m.rotor_bar_check_status, 'after', ''),
+	jsonb_build_object('field', 'rotor_bar_reason', 'before', m.rotor_bar_reason, 'after', ''),
+	jsonb_build_object('field', 'rotor_bar_remark', 'before', m.rotor_bar_remark, 'after', ''))
+FROM public.list_materials m
+WHERE NOT EXISTS (SELECT 1 FROM public.rotor_bar_checks c WHERE c.material_id = m.id)
+	AND (m.rotor_bar_check_date IS NOT NULL OR m.rotor_bar_check_status <> '' OR m.rotor_bar_reason <> '' OR m.rotor_bar_remark <> '');
+
+UPDATE public.list_materials m SET updated_at = now()
+WHERE NOT EXISTS (SELECT 1 FROM public.rotor_bar_checks c WHERE c.material_id = m.id)
+	AND (m.rotor_bar_check_date IS NOT NULL OR m.rotor_bar_check_status <> '' OR m.rotor_bar_reason <> '' OR m.rotor_bar_remark <> '');
@@ -0,0 +1 @@
+ALTER TABLE public.import_profiles DROP COLUMN IF EXISTS category;
@@ -0,0 +1,2 @@
+-- The category of the materials a profile imports when the file has no category column
+ALTER TABLE public.import_profiles ADD COLUMN IF NOT EXISTS category text NOT NULL DEFAULT 'HV Motor';
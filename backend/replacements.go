@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// defaultSlipTolerance is the rpm tolerance in percent used when rpm_tol is not given
+const defaultSlipTolerance = 2.0
+
+// replacementCriterion is one rule a candidate must pass to replace the target motor
+type replacementCriterion struct {
+	Weight int
+	Check  func(target, candidate Material, tol ReplacementTolerance) CriterionResult
+}
+
+// ReplacementTolerance holds how far a candidate may deviate from the target
+type ReplacementTolerance struct {
+	RPM        float64 `json:"rpm_tol"`
+	RPMPercent bool    `json:"rpm_tol_percent"`
+	Dimension  float64 `json:"dimension_tol"`
+}
+
+// CriterionResult is the outcome of one criterion for one candidate
+type CriterionResult struct {
+	Name   string  `json:"name"`
+	Pass   bool    `json:"pass"`
+	Target float64 `json:"target"`
+	Actual float64 `json:"actual"`
+	Detail string  `json:"detail"`
+}
+
+// ReplacementCandidate is a motor ranked against the target motor
+type ReplacementCandidate struct {
+	Material   Material `json:"material"`
+	Score      int      `json:"score"`
+	Compatible bool     `json:"compatible"`
+	// CapacityMargin is the capacity of the candidate minus that of the target
+	CapacityMargin float64           `json:"capacity_margin"`
+	Criteria       []CriterionResult `json:"criteria"`
+}
+
+// replacementSortFields are the fields ?sort= orders candidates by, ties keep the ranking of rankReplacements
+var replacementSortFields = []string{"capacity_margin", "id", "name", "plant", "score"}
+
+type ReplacementResponse struct {
+	Request struct {
+		ID             int                  `json:"id"`
+		Tolerance      ReplacementTolerance `json:"tolerance"`
+		CompatibleOnly bool                 `json:"compatible_only"`
+		Sort           []SortKey            `json:"sort"`
+		Limit          int                  `json:"limit"`
+		Offset         int                  `json:"offset"`
+	} `json:"request"`
+	Response struct {
+		Count   int                    `json:"count"`
+		Total   int                    `json:"total"`
+		Success bool                   `json:"success"`
+		Target  Material               `json:"target"`
+		Data    []ReplacementCandidate `json:"data"`
+	} `json:"response"`
+}
+
+// replacementCriteria are checked in order, the weights add up to a score of 100
+var replacementCriteria = []replacementCriterion{
+	{Weight: 30, Check: func(target, candidate Material, _ ReplacementTolerance) CriterionResult {
+		return checkWithin("voltage", target.Specifications.Voltage, candidate.Specifications.Voltage, 0)
+	}},
+	{Weight: 20, Check: func(target, candidate Material, _ ReplacementTolerance) CriterionResult {
+		result := newCriterionResult("capacity", target.Specifications.Capacity, candidate.Specifications.Capacity)
+		if result.Detail != "" {
+			return result
+		}
+		result.Pass = candidate.Specifications.Capacity >= target.Specifications.Capacity
+		if !result.Pass {
+			result.Detail = "capacity is lower than the target"
+		}
+		return result
+	}},
+	{Weight: 15, Check: func(target, candidate Material, tol ReplacementTolerance) CriterionResult {
+		tolerance := tol.RPM
+		if tol.RPMPercent {
+			tolerance = target.Specifications.RPM * tol.RPM / 100
+		}
+		return checkWithin("rpm", target.Specifications.RPM, candidate.Specifications.RPM, tolerance)
+	}},
+	{Weight: 10, Check: func(target, candidate Material, _ ReplacementTolerance) CriterionResult {
+		return checkWithin("frame", float64(target.Frame), float64(candidate.Frame), 0)
+	}},
+	{Weight: 10, Check: func(target, candidate Material, tol ReplacementTolerance) CriterionResult {
+		return checkWithin("shaft_diameter", target.Size.ShaftDiameter, candidate.Size.ShaftDiameter, tol.Dimension)
+	}},
+	{Weight: 5, Check: func(target, candidate Material, tol ReplacementTolerance) CriterionResult {
+		return checkWithin("c", target.Size.C, candidate.Size.C, tol.Dimension)
+	}},
+	{Weight: 5, Check: func(target, candidate Material, tol ReplacementTolerance) CriterionResult {
+		return checkWithin("e", target.Size.E, candidate.Size.E, tol.Dimension)
+	}},
+	{Weight: 5, Check: func(target, candidate Material, tol ReplacementTolerance) CriterionResult {
+		return checkWithin("h", target.Size.H, candidate.Size.H, tol.Dimension)
+	}},
+}
+
+func getReplacements(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := newQueryParser(r)
+	limit, offset := query.pagination()
+
+	// Parse tolerances, rpm defaults to the usual slip of an induction motor
+	tolerance := ReplacementTolerance{RPM: defaultSlipTolerance, RPMPercent: true}
+	if r.URL.Query().Get("rpm_tol") != "" {
+		tolerance.RPM, tolerance.RPMPercent = query.tolerance("rpm_tol", hvMotorCategory.unit("rpm"))
+	}
+	tolerance.Dimension, _ = query.tolerance("dimension_tol", hvMotorCategory.unit("shaft_diameter"))
+	compatibleOnly := query.bool("compatible")
+	sortKeys := query.sort("sort", replacementSortFields)
+	if !query.valid(w) {
+		return
+	}
+
+	target, err := materialRepo.Get(r.Context(), id)
+	if err != nil {
+		writeMaterialLookupError(w, id, err)
+		return
+	}
+
+	// Every motor of the same category is a candidate, the criteria decide how well it fits
+	page, err := materialRepo.List(r.Context(), QueryParams{Category: target.Category}, 0, 0)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting materials: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	candidates := rankReplacements(target, page.Materials, tolerance)
+	if compatibleOnly {
+		compatible := []ReplacementCandidate{}
+		for _, candidate := range candidates {
+			if candidate.Compatible {
+				compatible = append(compatible, candidate)
+			}
+		}
+		candidates = compatible
+	}
+	sortReplacements(candidates, sortKeys)
+
+	// Apply pagination in the code, the ranking is not expressible in SQL
+	total := len(candidates)
+	startIndex := offset
+	if startIndex > total {
+		startIndex = total
+	}
+	endIndex := offset + limit
+	if endIndex > total || limit == 0 {
+		endIndex = total
+	}
+	paginatedData := candidates[startIndex:endIndex]
+
+	// Construct response
+	var response ReplacementResponse
+	response.Request.ID = id
+	response.Request.Tolerance = tolerance
+	response.Request.CompatibleOnly = compatibleOnly
+	response.Request.Sort = sortKeys
+	response.Request.Limit = limit
+	response.Request.Offset = offset
+	response.Response.Count = len(paginatedData)
+	response.Response.Total = total
+	response.Response.Success = true
+	response.Response.Target = target
+	response.Response.Data = paginatedData
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Function to score every material except the target and sort them best first.
+// Fully compatible motors come first, then higher scores, then the smallest capacity margin.
+func rankReplacements(target Material, materials []Material, tolerance ReplacementTolerance) []ReplacementCandidate {
+	candidates := []ReplacementCandidate{}
+	for _, material := range materials {
+		if material.ID == target.ID {
+			continue
+		}
+
+		candidate := ReplacementCandidate{
+			Material:       material,
+			Compatible:     true,
+			CapacityMargin: material.Specifications.Capacity - target.Specifications.Capacity,
+		}
+		for _, criterion := range replacementCriteria {
+			result := criterion.Check(target, material, tolerance)
+			if result.Pass {
+				candidate.Score += criterion.Weight
+			} else {
+				candidate.Compatible = false
+			}
+			candidate.Criteria = append(candidate.Criteria, result)
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Compatible != b.Compatible {
+			return a.Compatible
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.CapacityMargin != b.CapacityMargin {
+			return math.Abs(a.CapacityMargin) < math.Abs(b.CapacityMargin)
+		}
+		return a.Material.ID < b.Material.ID
+	})
+
+	return candidates
+}
+
+// Function to reorder ranked candidates by keys, candidates equal on every key keep their rank
+func sortReplacements(candidates []ReplacementCandidate, keys []SortKey) {
+	if len(keys) == 0 {
+		return
+	}
+
+	// compare returns a negative number when a sorts before b ascending
+	compare := func(field string, a, b ReplacementCandidate) int {
+		switch field {
+		case "capacity_margin":
+			return compareFloats(a.CapacityMargin, b.CapacityMargin)
+		case "score":
+			return a.Score - b.Score
+		case "plant":
+			return strings.Compare(a.Material.Plant, b.Material.Plant)
+		case "name":
+			return strings.Compare(a.Material.Name, b.Material.Name)
+		default:
+			return a.Material.ID - b.Material.ID
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		for _, key := range keys {
+			order := compare(key.Field, candidates[i], candidates[j])
+			if key.Desc {
+				order = -order
+			}
+			if order != 0 {
+				return order < 0
+			}
+		}
+		return false
+	})
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Function to start a criterion result, Detail is set when either value is unknown
+func newCriterionResult(name string, target, actual float64) CriterionResult {
+	result := CriterionResult{Name: name, Target: target, Actual: actual}
+	// The importer stores "-" placeholders as 0, a missing value can not be confirmed to fit
+	if target == 0 || actual == 0 {
+		result.Detail = "value is missing"
+	}
+	return result
+}
+
+// Function to check that actual is within tolerance of target
+func checkWithin(name string, target, actual, tolerance float64) CriterionResult {
+	result := newCriterionResult(name, target, actual)
+	if result.Detail != "" {
+		return result
+	}
+
+	difference := math.Abs(actual - target)
+	result.Pass = difference <= tolerance
+	if !result.Pass {
+		result.Detail = fmt.Sprintf("differs by %g, tolerance is %g", difference, tolerance)
+	}
+	return result
+}
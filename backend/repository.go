@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Errors every MaterialRepository returns, whatever stores the materials
+var (
+	ErrMaterialNotFound = errors.New("material not found")
+	ErrMaterialExists   = errors.New("material already exists")
+)
+
+// MaterialRepository stores materials, the material handlers reach them only through materialRepo
+type MaterialRepository interface {
+	// List returns one page of the materials matching params, in the order of params.Sort, ignoring params.Search
+	List(ctx context.Context, params QueryParams, limit, offset int) (MaterialPage, error)
+	// Search is List narrowed by params.Search, best match first unless params.Sort is given, with Match set
+	Search(ctx context.Context, params QueryParams, limit, offset int) (MaterialPage, error)
+	// Get returns ErrMaterialNotFound when id does not exist
+	Get(ctx context.Context, id int) (Material, error)
+	// Create stores a new material, its quantities as opening stock, ErrMaterialExists when the id is taken
+	Create(ctx context.Context, material Material, change MaterialChange) (Material, error)
+	// Update replaces every field of a stored material but its quantities
+	Update(ctx context.Context, material Material, change MaterialChange) (Material, error)
+	Delete(ctx context.Context, id int, change MaterialChange) error
+	// Facets counts the values of params.Facets among every material matching params
+	Facets(ctx context.Context, params QueryParams) (map[string][]FacetValue, error)
+	// Stream is List or Search read one material at a time, so an export never holds every row
+	Stream(ctx context.Context, params QueryParams, limit, offset int) (MaterialRows, error)
+}
+
+// RecordRepository stores what is recorded about materials besides their fields, the handlers reach it only through recordRepo.
+// Adding a record changes the material it belongs to, so every Add is audited like a material change.
+type RecordRepository interface {
+	// RotorBarChecks returns the checks of a material, latest first
+	RotorBarChecks(ctx context.Context, materialID int) ([]RotorBarCheck, error)
+	// AddRotorBarCheck returns ErrMaterialNotFound when the material does not exist
+	AddRotorBarCheck(ctx context.Context, check RotorBarCheck, change MaterialChange) (RotorBarCheck, error)
+	// StockMovements returns the movements of a material, latest first
+	StockMovements(ctx context.Context, materialID int) ([]StockMovement, error)
+	// AddStockMovement returns a StockRejectedError when the movement takes more than a location holds
+	AddStockMovement(ctx context.Context, movement StockMovement, change MaterialChange) (StockMovement, error)
+	// StartingCurrentEvents returns the events of a material, latest first
+	StartingCurrentEvents(ctx context.Context, materialID int) ([]StartingCurrentEvent, error)
+	// AddStartingCurrentEvent also returns the material with its starting current dates moved by the event
+	AddStartingCurrentEvent(ctx context.Context, event StartingCurrentEvent, change MaterialChange) (StartingCurrentEvent, Material, error)
+	// SpareCoverage counts the motors of category per family and plant, and the motors that could not be grouped
+	SpareCoverage(ctx context.Context, category string) ([]FamilyCoverage, int, error)
+	// AuditLog returns one page of the audit entries matching filter, latest first
+	AuditLog(ctx context.Context, filter auditFilter, limit, offset int) (AuditPage, error)
+}
+
+// MaterialPage is one page of materials and the number of materials on every page
+type MaterialPage struct {
+	Materials []Material
+	Total     int
+}
+
+// MaterialRows reads materials one at a time like pgx.Rows, Close must be called when done
+type MaterialRows interface {
+	Next() bool
+	Material() (Material, error)
+	Err() error
+	Close()
+}
+
+// AuditPage is one page of the audit log and the number of entries on every page
+type AuditPage struct {
+	Entries []AuditEntry
+	Total   int
+}
+
+// MaterialChange is who changes a material and through which endpoint, for the audit log
+type MaterialChange struct {
+	Actor    string
+	Endpoint string
+}
+
+// materialRepo, recordRepo, userRepo and importRepo are the repositories of the handlers, set at startup
+var (
+	materialRepo MaterialRepository
+	recordRepo   RecordRepository
+	userRepo     UserRepository
+	importRepo   ImportRepository
+)
+
+// Function to describe the change a request makes, by the current user
+func requestChange(r *http.Request) MaterialChange {
+	return MaterialChange{Actor: currentUser(r).Username, Endpoint: r.Method + " " + r.URL.Path}
+}
+
+// pgMaterialRepository stores materials in list_materials and their records in the tables referencing it,
+// every write is audited in the same transaction
+type pgMaterialRepository struct {
+	db *pgxpool.Pool
+}
+
+func newPgMaterialRepository(db *pgxpool.Pool) *pgMaterialRepository {
+	return &pgMaterialRepository{db: db}
+}
+
+func (repo *pgMaterialRepository) List(ctx context.Context, params QueryParams, limit, offset int) (MaterialPage, error) {
+	params.Search = ""
+	return repo.page(ctx, params, limit, offset)
+}
+
+func (repo *pgMaterialRepository) Search(ctx context.Context, params QueryParams, limit, offset int) (MaterialPage, error) {
+	return repo.page(ctx, params, limit, offset)
+}
+
+// Function to count and select one page of the materials matching params
+func (repo *pgMaterialRepository) page(ctx context.Context, params QueryParams, limit, offset int) (MaterialPage, error) {
+	total, err := countMaterialsByParams(ctx, repo.db, params)
+	if err != nil {
+		return MaterialPage{}, err
+	}
+
+	// Execute the dynamic SELECT query, the database applies LIMIT/OFFSET
+	materials, err := selectMaterialsByParams(ctx, repo.db, params, limit, offset)
+	if err != nil {
+		return MaterialPage{}, err
+	}
+
+	return MaterialPage{Materials: materials, Total: total}, nil
+}
+
+func (repo *pgMaterialRepository) Get(ctx context.Context, id int) (Material, error) {
+	material, err := selectMaterialByID(ctx, repo.db, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Material{}, ErrMaterialNotFound
+	}
+	return material, err
+}
+
+func (repo *pgMaterialRepository) Create(ctx context.Context, material Material, change MaterialChange) (Material, error) {
+	// The quantities become opening stock movements, the stored ones are derived from them
+	created, err := auditChange(ctx, repo.db, change, AuditCreate, material.ID, func(tx pgx.Tx, _ *Material) (*Material, error) {
+		if _, err := insertMaterial(ctx, tx, material); err != nil {
+			return nil, err
+		}
+		if err := insertOpeningBalances(ctx, tx, material, change.Actor); err != nil {
+			return nil, err
+		}
+		created, err := selectMaterialByID(ctx, tx, material.ID)
+		return &created, err
+	})
+	if isUniqueViolation(err) {
+		return Material{}, ErrMaterialExists
+	}
+	if err != nil {
+		return Material{}, err
+	}
+	return *created, nil
+}
+
+func (repo *pgMaterialRepository) Update(ctx context.Context, material Material, change MaterialChange) (Material, error) {
+	updated, err := auditChange(ctx, repo.db, change, AuditUpdate, material.ID, func(tx pgx.Tx, stored *Material) (*Material, error) {
+		// Quantities are balances of the stock ledger and only change through stock movements
+		material.Installed, material.StandBy, material.Spare = stored.Installed, stored.StandBy, stored.Spare
+		updated, err := updateMaterial(ctx, tx, material)
+		return &updated, err
+	})
+	if isUniqueViolation(err) {
+		return Material{}, ErrMaterialExists
+	}
+	if err != nil {
+		return Material{}, err
+	}
+	return *updated, nil
+}
+
+func (repo *pgMaterialRepository) Delete(ctx context.Context, id int, change MaterialChange) error {
+	_, err := auditChange(ctx, repo.db, change, AuditDelete, id, func(tx pgx.Tx, _ *Material) (*Material, error) {
+		return nil, deleteMaterialByID(ctx, tx, id)
+	})
+	return err
+}
+
+func (repo *pgMaterialRepository) Facets(ctx context.Context, params QueryParams) (map[string][]FacetValue, error) {
+	return selectFacets(ctx, repo.db, params)
+}
+
+func (repo *pgMaterialRepository) Stream(ctx context.Context, params QueryParams, limit, offset int) (MaterialRows, error) {
+	rows, err := queryMaterialsByParams(ctx, repo.db, params, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &pgMaterialRows{rows: rows, params: params}, nil
+}
+
+// pgMaterialRows scans the rows of buildSelectQuery as they are read from the connection
+type pgMaterialRows struct {
+	rows   pgx.Rows
+	params QueryParams
+}
+
+func (rows *pgMaterialRows) Next() bool {
+	return rows.rows.Next()
+}
+
+func (rows *pgMaterialRows) Material() (Material, error) {
+	return scanMaterialByParams(rows.rows, rows.params)
+}
+
+func (rows *pgMaterialRows) Err() error {
+	return rows.rows.Err()
+}
+
+func (rows *pgMaterialRows) Close() {
+	rows.rows.Close()
+}
+
+func (repo *pgMaterialRepository) RotorBarChecks(ctx context.Context, materialID int) ([]RotorBarCheck, error) {
+	return selectRotorBarChecks(ctx, repo.db, materialID)
+}
+
+func (repo *pgMaterialRepository) AddRotorBarCheck(ctx context.Context, check RotorBarCheck, change MaterialChange) (RotorBarCheck, error) {
+	// The check changes the material's rotor_bar columns through a trigger, so the material is audited
+	var created RotorBarCheck
+	_, err := auditChange(ctx, repo.db, change, AuditRotorBarCheck, check.MaterialID, func(tx pgx.Tx, _ *Material) (*Material, error) {
+		var err error
+		if created, err = insertRotorBarCheck(ctx, tx, check); err != nil {
+			return nil, err
+		}
+		updated, err := selectMaterialByID(ctx, tx, check.MaterialID)
+		return &updated, err
+	})
+	return created, err
+}
+
+func (repo *pgMaterialRepository) StockMovements(ctx context.Context, materialID int) ([]StockMovement, error) {
+	return selectStockMovements(ctx, repo.db, materialID)
+}
+
+func (repo *pgMaterialRepository) AddStockMovement(ctx context.Context, movement StockMovement, change MaterialChange) (StockMovement, error) {
+	// The movement changes the material's quantities through a trigger, so the material is audited
+	var created StockMovement
+	_, err := auditChange(ctx, repo.db, change, AuditStockMovement, movement.MaterialID, func(tx pgx.Tx, _ *Material) (*Material, error) {
+		var err error
+		if created, err = insertStockMovement(ctx, tx, movement); err != nil {
+			return nil, err
+		}
+		updated, err := selectMaterialByID(ctx, tx, movement.MaterialID)
+		return &updated, err
+	})
+	// check_stock_movement raises a check violation for a balance that would go negative
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == checkViolation {
+		return StockMovement{}, StockRejectedError{Reason: pgErr.Message}
+	}
+	return created, err
+}
+
+func (repo *pgMaterialRepository) StartingCurrentEvents(ctx context.Context, materialID int) ([]StartingCurrentEvent, error) {
+	return selectStartingCurrentEvents(ctx, repo.db, materialID)
+}
+
+func (repo *pgMaterialRepository) AddStartingCurrentEvent(ctx context.Context, event StartingCurrentEvent, change MaterialChange) (StartingCurrentEvent, Material, error) {
+	// The event moves the material's starting_current_last_* dates through a trigger, so the material is audited
+	var created StartingCurrentEvent
+	updated, err := auditChange(ctx, repo.db, change, AuditStartingCurrentEvent, event.MaterialID, func(tx pgx.Tx, _ *Material) (*Material, error) {
+		var err error
+		if created, err = insertStartingCurrentEvent(ctx, tx, event); err != nil {
+			return nil, err
+		}
+		updated, err := selectMaterialByID(ctx, tx, event.MaterialID)
+		return &updated, err
+	})
+	if err != nil {
+		return StartingCurrentEvent{}, Material{}, err
+	}
+	return created, *updated, nil
+}
+
+func (repo *pgMaterialRepository) SpareCoverage(ctx context.Context, category string) ([]FamilyCoverage, int, error) {
+	return selectSpareCoverage(ctx, repo.db, category)
+}
+
+func (repo *pgMaterialRepository) AuditLog(ctx context.Context, filter auditFilter, limit, offset int) (AuditPage, error) {
+	total, err := countAuditEntries(ctx, repo.db, filter)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	entries, err := selectAuditEntries(ctx, repo.db, filter, limit, offset)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	return AuditPage{Entries: entries, Total: total}, nil
+}
+
+// Function to write the error of a failed material lookup, 404 when it does not exist
+func writeMaterialLookupError(w http.ResponseWriter, id int, err error) {
+	if errors.Is(err, ErrMaterialNotFound) {
+		http.Error(w, fmt.Sprintf("Material %d not found", id), http.StatusNotFound)
+		return
+	}
+	http.Error(w, fmt.Sprintf("Error selecting material: %v", err), http.StatusInternalServerError)
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Function to open the database of TEST_DATABASE_URL with every migration applied, skipping the test without one.
+// The database is written to, so it must never be a production one.
+func openTestDatabase(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatalf("unable to connect to the test database: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	if _, err := migrateUp(ctx, pool, 0); err != nil {
+		t.Fatalf("unable to migrate the test database: %v", err)
+	}
+	return pool
+}
+
+// repositoryTestPlant holds the materials of these tests only, so queries on it ignore every other row
+const repositoryTestPlant = "Repository Test"
+
+// Function to store materials, clearing what an earlier run that failed halfway left behind, and delete them after the test
+func createTestMaterials(t *testing.T, repo *pgMaterialRepository, materials ...Material) {
+	t.Helper()
+
+	ctx := context.Background()
+	change := MaterialChange{Actor: "repository-test", Endpoint: "test"}
+	for _, material := range materials {
+		id := material.ID
+		if err := repo.Delete(ctx, id, change); err != nil && !errors.Is(err, ErrMaterialNotFound) {
+			t.Fatalf("unable to clear material %d: %v", id, err)
+		}
+		if _, err := repo.Create(ctx, material, change); err != nil {
+			t.Fatalf("unable to create material %d: %v", id, err)
+		}
+		t.Cleanup(func() { _ = repo.Delete(ctx, id, change) })
+	}
+}
+
+// Function to return the IDs of a page in order
+func pageIDs(page MaterialPage) []int {
+	ids := []int{}
+	for _, material := range page.Materials {
+		ids = append(ids, material.ID)
+	}
+	return ids
+}
+
+func TestPgMaterialRepository(t *testing.T) {
+	repo := newPgMaterialRepository(openTestDatabase(t))
+	ctx := context.Background()
+	change := MaterialChange{Actor: "repository-test", Endpoint: "test"}
+
+	// An id far above the imported ones, left behind by an earlier run that failed halfway
+	const id = 990001
+	if err := repo.Delete(ctx, id, change); err != nil && !errors.Is(err, ErrMaterialNotFound) {
+		t.Fatalf("unable to clear material %d: %v", id, err)
+	}
+
+	created, err := repo.Create(ctx, testMotor(id, "Plant A", "Repository test", 560, 6600, 1), change)
+	if err != nil {
+		t.Fatalf("unable to create material: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Delete(ctx, id, change) })
+	if created.Installed != 1 || created.Spare != 1 {
+		t.Errorf("created has %d installed and %d spare, want 1 and 1", created.Installed, created.Spare)
+	}
+
+	movement := StockMovement{MaterialID: id, Movement: MovementInstall, FromLocation: LocationSpare, Quantity: 1, Reason: "Bearing failure", Actor: change.Actor}
+	if err := validateStockMovement(&movement); err != nil {
+		t.Fatalf("invalid movement: %v", err)
+	}
+	if _, err := repo.AddStockMovement(ctx, movement, change); err != nil {
+		t.Fatalf("unable to add movement: %v", err)
+	}
+	// check_stock_movement refuses to take the spare a second time
+	var rejected StockRejectedError
+	if _, err := repo.AddStockMovement(ctx, movement, change); !errors.As(err, &rejected) {
+		t.Errorf("second install err = %v, want StockRejectedError", err)
+	}
+
+	material, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("unable to get material: %v", err)
+	}
+	if material.Installed != 2 || material.Spare != 0 {
+		t.Errorf("material has %d installed and %d spare, want 2 and 0", material.Installed, material.Spare)
+	}
+
+	page, err := repo.AuditLog(ctx, auditFilter{MaterialID: id, Actor: change.Actor}, 10, 0)
+	if err != nil {
+		t.Fatalf("unable to read the audit log: %v", err)
+	}
+	if page.Total < 2 || page.Entries[0].Action != AuditStockMovement {
+		t.Errorf("audit log = %+v, want the movement after the create", page.Entries)
+	}
+
+	// A plant role only reads the entries of materials on its plants, an actor filter only that actor's
+	page, err = repo.AuditLog(ctx, auditFilter{MaterialID: id, Plants: []string{"plant b"}}, 10, 0)
+	if err != nil {
+		t.Fatalf("unable to read the audit log: %v", err)
+	}
+	if page.Total != 0 {
+		t.Errorf("audit log on plant b = %+v, want none of a Plant A material", page.Entries)
+	}
+	page, err = repo.AuditLog(ctx, auditFilter{MaterialID: id, Plants: []string{"plant a"}, Actor: "nobody"}, 10, 0)
+	if err != nil {
+		t.Fatalf("unable to read the audit log: %v", err)
+	}
+	if page.Total != 0 {
+		t.Errorf("audit log of another actor = %+v, want none", page.Entries)
+	}
+	page, err = repo.AuditLog(ctx, auditFilter{MaterialID: id, Plants: []string{"plant a"}}, 10, 0)
+	if err != nil {
+		t.Fatalf("unable to read the audit log: %v", err)
+	}
+	if page.Total < 2 {
+		t.Errorf("audit log on plant a = %+v, want the create and the movement", page.Entries)
+	}
+
+	if err := repo.Delete(ctx, id, change); err != nil {
+		t.Fatalf("unable to delete material: %v", err)
+	}
+	if _, err := repo.Get(ctx, id); !errors.Is(err, ErrMaterialNotFound) {
+		t.Errorf("get after delete err = %v, want ErrMaterialNotFound", err)
+	}
+	// The records go with the material, its history stays
+	movements, err := repo.StockMovements(ctx, id)
+	if err != nil {
+		t.Fatalf("unable to select movements: %v", err)
+	}
+	if len(movements) != 0 {
+		t.Errorf("%d movements left after the delete, want none", len(movements))
+	}
+	page, err = repo.AuditLog(ctx, auditFilter{MaterialID: id}, 10, 0)
+	if err != nil {
+		t.Fatalf("unable to read the audit log: %v", err)
+	}
+	if len(page.Entries) == 0 || page.Entries[0].Action != AuditDelete {
+		t.Errorf("history = %+v, want the delete first", page.Entries)
+	}
+}
+
+func TestPgMaterialQueries(t *testing.T) {
+	repo := newPgMaterialRepository(openTestDatabase(t))
+	ctx := context.Background()
+
+	spare := testMotor(990013, repositoryTestPlant, "Spare motor", 0, 0, 2)
+	spare.Maker = "Quokka Motors"
+	// A schedule left on another category is not an HV motor check
+	lvMotor := testMotor(990014, repositoryTestPlant, "LV pump", 0, 0, 0)
+	lvMotor.Category, lvMotor.Area = "LV Motor", "Substation 2"
+	lvMotor.StartingCurrent.Frequency = FrequencyMonthly
+	createTestMaterials(t, repo,
+		testMotor(990011, repositoryTestPlant, "Pump", 560, 6600, 1),
+		testMotor(990012, repositoryTestPlant, "Fan", 1000, 6600, 0),
+		spare, lvMotor,
+	)
+	float := func(v float64) *float64 { return &v }
+	onPlant := []textColumn{{Column: "plant", Name: "plant", Value: repositoryTestPlant}}
+
+	tests := []struct {
+		name   string
+		params QueryParams
+		ids    []int
+	}{
+		{"capacity 1e3", QueryParams{Capacity: NumericFilter{Value: float(1000)}}, []int{990012}},
+		{"voltage 6.6kV", QueryParams{Voltage: NumericFilter{Value: float(6600)}}, []int{990011, 990012}},
+		// An explicit zero is a filter, not a missing one
+		{"voltage 0", QueryParams{Voltage: NumericFilter{Value: float(0)}}, []int{990013}},
+		{"capacity 0 to 600", QueryParams{Capacity: NumericFilter{Min: float(0), Max: float(600)}}, []int{990011, 990013}},
+		// Capacity is a lower bound, widened by its tolerance
+		{"capacity 1200", QueryParams{Capacity: NumericFilter{Value: float(1200)}}, []int{}},
+		{"capacity 1200 within 20%", QueryParams{Capacity: NumericFilter{Value: float(1200), Tolerance: 20, TolPercent: true}}, []int{990012}},
+		{"by capacity descending", QueryParams{Sort: []SortKey{{Field: "capacity", Desc: true}}}, []int{990012, 990011, 990013}},
+	}
+	for _, test := range tests {
+		test.params.Category, test.params.Texts = hvMotorCategory.Name, onPlant
+		page, err := repo.List(ctx, test.params, 0, 0)
+		if err != nil {
+			t.Fatalf("%s: unable to list: %v", test.name, err)
+		}
+		if got := pageIDs(page); fmt.Sprint(got) != fmt.Sprint(test.ids) {
+			t.Errorf("%s: ids = %v, want %v", test.name, got, test.ids)
+		}
+	}
+
+	search, err := repo.Search(ctx, QueryParams{Search: "quokka", Texts: onPlant}, 0, 0)
+	if err != nil {
+		t.Fatalf("unable to search: %v", err)
+	}
+	if got := pageIDs(search); fmt.Sprint(got) != "[990013]" {
+		t.Errorf("search quokka = %v, want [990013]", got)
+	}
+
+	// The overdue report lists the HV motors of a plant and area
+	for area, want := range map[string]string{"": "[990011 990012 990013]", "Substation 2": "[]"} {
+		params := QueryParams{Category: hvMotorCategory.Name, Texts: onPlant}
+		if area != "" {
+			params.Texts = append(params.Texts, textColumn{Column: "area", Name: "area", Value: area})
+		}
+		page, err := repo.List(ctx, params, 0, 0)
+		if err != nil {
+			t.Fatalf("unable to list: %v", err)
+		}
+		if got := pageIDs(page); fmt.Sprint(got) != want {
+			t.Errorf("HV motors in %q = %v, want %s", area, got, want)
+		}
+	}
+
+	facets, err := repo.Facets(ctx, QueryParams{Texts: onPlant, Facets: []string{"area", "voltage"}})
+	if err != nil {
+		t.Fatalf("unable to count facets: %v", err)
+	}
+	if areas := fmt.Sprint(facets["area"]); !strings.Contains(areas, "Substation 1 3") || !strings.Contains(areas, "Substation 2 1") {
+		t.Errorf("area facet = %v, want 3 on Substation 1 and 1 on Substation 2", facets["area"])
+	}
+	if len(facets["voltage"]) != 2 {
+		t.Errorf("voltage facet = %v, want 6600 and no value", facets["voltage"])
+	}
+}
+
+func TestPgRecordDerivation(t *testing.T) {
+	repo := newPgMaterialRepository(openTestDatabase(t))
+	ctx := context.Background()
+	change := MaterialChange{Actor: "repository-test", Endpoint: "test"}
+
+	const id = 990021
+	createTestMaterials(t, repo, testMotor(id, repositoryTestPlant, "Pump", 560, 6600, 1))
+
+	// The material follows the latest check date, not the latest check recorded
+	for _, check := range []RotorBarCheck{
+		{MaterialID: id, CheckDate: "2026-03-01", Status: "OK", Inspector: "Sam"},
+		{MaterialID: id, CheckDate: "2025-11-15", Status: "CRACKED", Inspector: "Sam"},
+	} {
+		if err := validateRotorBarCheck(&check); err != nil {
+			t.Fatalf("invalid check: %v", err)
+		}
+		if _, err := repo.AddRotorBarCheck(ctx, check, change); err != nil {
+			t.Fatalf("unable to add check: %v", err)
+		}
+	}
+	material, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("unable to get material: %v", err)
+	}
+	if material.RotorBar.CheckStatus != "OK" {
+		t.Errorf("rotor bar status = %q, want OK", material.RotorBar.CheckStatus)
+	}
+	checks, err := repo.RotorBarChecks(ctx, id)
+	if err != nil {
+		t.Fatalf("unable to select checks: %v", err)
+	}
+	if len(checks) != 2 || checks[0].CheckDate != "2026-03-01" {
+		t.Errorf("checks = %+v, want 2 with 2026-03-01 first", checks)
+	}
+
+	current := 310.5
+	event := StartingCurrentEvent{MaterialID: id, Event: EventCheck, EventDate: "2026-04-20", MeasuredCurrent: &current, RecordedBy: change.Actor}
+	if err := validateStartingCurrentEvent(&event); err != nil {
+		t.Fatalf("invalid event: %v", err)
+	}
+	_, material, err = repo.AddStartingCurrentEvent(ctx, event, change)
+	if err != nil {
+		t.Fatalf("unable to add event: %v", err)
+	}
+	if last := material.StartingCurrent.LastCheck; last == nil || last.Format("2006-01-02") != "2026-04-20" {
+		t.Errorf("last check = %v, want 2026-04-20", last)
+	}
+
+	// Stock only moves out of a location holding enough of it
+	movement := StockMovement{MaterialID: id, Movement: MovementInstall, FromLocation: LocationSpare, Quantity: 2, Reason: "Bearing failure", Actor: change.Actor}
+	if err := validateStockMovement(&movement); err != nil {
+		t.Fatalf("invalid movement: %v", err)
+	}
+	var rejected StockRejectedError
+	if _, err := repo.AddStockMovement(ctx, movement, change); !errors.As(err, &rejected) {
+		t.Errorf("install of 2 out of 1 spare err = %v, want StockRejectedError", err)
+	}
+	movements, err := repo.StockMovements(ctx, id)
+	if err != nil {
+		t.Fatalf("unable to select movements: %v", err)
+	}
+	// The opening balances of installed and spare
+	if balances := stockBalances(movements); balances[LocationInstalled] != 1 || balances[LocationSpare] != 1 {
+		t.Errorf("balances = %v, want the opening 1 installed and 1 spare", balances)
+	}
+}
+
+func TestPgSpareCoverage(t *testing.T) {
+	repo := newPgMaterialRepository(openTestDatabase(t))
+	ctx := context.Background()
+
+	_, unclassifiedBefore, err := repo.SpareCoverage(ctx, hvMotorCategory.Name)
+	if err != nil {
+		t.Fatalf("unable to select spare coverage: %v", err)
+	}
+
+	// Voltages no real motor has, so the families hold the test motors only
+	pumpA, pumpB, fan := testMotor(990031, repositoryTestPlant, "Pump A", 561, 6601, 1), testMotor(990032, "Repository Test B", "Pump B", 561, 6601, 0), testMotor(990033, repositoryTestPlant, "Fan", 1001, 6601, 0)
+	createTestMaterials(t, repo, pumpA, pumpB, fan, testMotor(990034, repositoryTestPlant, "Unknown", 0, 6601, 0))
+
+	families, unclassified, err := repo.SpareCoverage(ctx, hvMotorCategory.Name)
+	if err != nil {
+		t.Fatalf("unable to select spare coverage: %v", err)
+	}
+	if unclassified != unclassifiedBefore+1 {
+		t.Errorf("unclassified = %d, want %d", unclassified, unclassifiedBefore+1)
+	}
+	found := map[float64]FamilyCoverage{}
+	for _, family := range families {
+		if family.Family.Voltage == 6601 {
+			found[family.Family.Capacity] = family
+		}
+	}
+	if pumps := found[561]; pumps.Motors != 2 || pumps.Installed != 2 || pumps.Spare != 1 || len(pumps.Plants) != 2 {
+		t.Errorf("pumps = %+v, want 2 motors with 1 spare over 2 plants", pumps)
+	}
+	if fans := found[1001]; fans.Motors != 1 || fans.Spare != 0 || len(fans.Plants) != 1 || fans.Plants[0].MaterialIDs[0] != 990033 {
+		t.Errorf("fans = %+v, want the fan alone", fans)
+	}
+}
+
+func TestPgImportRun(t *testing.T) {
+	db := openTestDatabase(t)
+	repo, imports := newPgMaterialRepository(db), newPgImportRepository(db)
+	ctx := context.Background()
+	change := MaterialChange{Actor: "repository-test", Endpoint: "test"}
+
+	// A plant of its own, so every row is new whatever an earlier run left behind
+	plant := repositoryTestPlant + " " + time.Now().Format("150405.000000")
+	rows, err := csv.NewReader(strings.NewReader("Plant,MCC,Tag,kW,Kind,Rating\n" +
+		plant + ",MCC 1,P-101,55 kW,,\n" +
+		plant + ",MCC 1,TR-1,,Transformer,2.5 MVA\n")).ReadAll()
+	if err != nil {
+		t.Fatalf("unable to read the file: %v", err)
+	}
+	profile := ImportProfile{Name: "repository-test", Category: "lv-motor", Mapping: map[string]string{
+		"plant": "Plant", "area": "MCC", "name": "Tag", "capacity": "kW", "category": "Kind", "specs.rating": "Rating",
+	}}
+	if err := prepareImportProfile(&profile); err != nil {
+		t.Fatalf("invalid profile: %v", err)
+	}
+
+	report, err := imports.Run(ctx, rows, importOptions{Profile: profile, Commit: true, Actor: change.Actor, Endpoint: "test"})
+	if err != nil {
+		t.Fatalf("unable to import: %v", err)
+	}
+	for _, row := range report.Rows {
+		if row.MaterialID != 0 {
+			id := row.MaterialID
+			t.Cleanup(func() { _ = repo.Delete(ctx, id, change) })
+		}
+	}
+	if !report.Committed || report.Summary.Created != 2 {
+		t.Fatalf("report = %+v, want 2 materials created", report)
+	}
+
+	motor, err := repo.Get(ctx, report.Rows[0].MaterialID)
+	if err != nil {
+		t.Fatalf("unable to get the motor: %v", err)
+	}
+	if motor.Category != "LV Motor" || motor.Specs["capacity"] != 55.0 {
+		t.Errorf("motor = %+v, want an LV Motor with capacity 55 in specs", motor)
+	}
+	transformer, err := repo.Get(ctx, report.Rows[1].MaterialID)
+	if err != nil {
+		t.Fatalf("unable to get the transformer: %v", err)
+	}
+	if transformer.Category != "Transformer" || transformer.Specs["rating"] != 2500.0 {
+		t.Errorf("transformer = %+v, want a Transformer rated 2500 kVA", transformer)
+	}
+
+	// The same file again changes nothing
+	report, err = imports.Run(ctx, rows, importOptions{Profile: profile, Commit: true, Actor: change.Actor, Endpoint: "test"})
+	if err != nil {
+		t.Fatalf("unable to import again: %v", err)
+	}
+	if report.Summary.Unchanged != 2 {
+		t.Errorf("summary = %+v, want 2 unchanged", report.Summary)
+	}
+}
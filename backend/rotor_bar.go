@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// rotorBarCheckColumns is the column list every rotor bar check query selects, in scanRotorBarCheck order
+const rotorBarCheckColumns = "id, material_id, to_char(check_date, 'YYYY-MM-DD'), status, inspector, reason, findings, attachments, created_at"
+
+// RotorBarCheck is one rotor bar inspection of a motor, CheckDate is formatted YYYY-MM-DD
+type RotorBarCheck struct {
+	ID          int64     `json:"id"`
+	MaterialID  int       `json:"material_id"`
+	CheckDate   string    `json:"check_date"`
+	Status      string    `json:"status"`
+	Inspector   string    `json:"inspector"`
+	Reason      string    `json:"reason"`
+	Findings    string    `json:"findings"`
+	Attachments []string  `json:"attachments"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type RotorBarChecksResponse struct {
+	Response struct {
+		Count   int             `json:"count"`
+		Success bool            `json:"success"`
+		Data    []RotorBarCheck `json:"data"`
+	} `json:"response"`
+}
+
+type RotorBarCheckResponse struct {
+	Response struct {
+		Success bool          `json:"success"`
+		Data    RotorBarCheck `json:"data"`
+	} `json:"response"`
+}
+
+func getRotorBarChecks(w http.ResponseWriter, r *http.Request, id int) {
+	if _, err := materialRepo.Get(r.Context(), id); err != nil {
+		writeMaterialLookupError(w, id, err)
+		return
+	}
+
+	checks, err := recordRepo.RotorBarChecks(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting rotor bar checks: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response RotorBarChecksResponse
+	response.Response.Count = len(checks)
+	response.Response.Success = true
+	response.Response.Data = checks
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func createRotorBarCheck(w http.ResponseWriter, r *http.Request, id int) {
+	material, err := materialRepo.Get(r.Context(), id)
+	if err != nil {
+		writeMaterialLookupError(w, id, err)
+		return
+	}
+	if !authorize(w, r, RolePlanner, material.Plant) {
+		return
+	}
+
+	var check RotorBarCheck
+	if err := decodeJSON(r, &check); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	check.MaterialID = id
+	if err := validateRotorBarCheck(&check); err != nil {
+		writeInputError(w, err)
+		return
+	}
+
+	created, err := recordRepo.AddRotorBarCheck(r.Context(), check, requestChange(r))
+	// The material can still be deleted between the lookup and the insert
+	if errors.Is(err, ErrMaterialNotFound) {
+		http.Error(w, fmt.Sprintf("Material %d not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error inserting rotor bar check: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response RotorBarCheckResponse
+	response.Response.Success = true
+	response.Response.Data = created
+
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// Function to normalise a decoded rotor bar check and validate it
+func validateRotorBarCheck(check *RotorBarCheck) error {
+	var problems InputErrors
+
+	check.Status = strings.ToUpper(strings.TrimSpace(check.Status))
+	check.Inspector = strings.TrimSpace(check.Inspector)
+	if check.Attachments == nil {
+		check.Attachments = []string{}
+	}
+
+	if _, err := time.Parse("2006-01-02", check.CheckDate); err != nil {
+		problems = append(problems, InputError{Field: "check_date", Value: check.CheckDate, Reason: "must be a YYYY-MM-DD date"})
+	}
+	if check.Status == "" {
+		problems = append(problems, InputError{Field: "status", Reason: "is required"})
+	}
+	if check.Inspector == "" {
+		problems = append(problems, InputError{Field: "inspector", Reason: "is required"})
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
+
+// Function to select the rotor bar checks of a material, latest first
+func selectRotorBarChecks(ctx context.Context, db querier, materialID int) ([]RotorBarCheck, error) {
+	rows, err := db.Query(ctx,
+		"SELECT "+rotorBarCheckColumns+" FROM public.rotor_bar_checks WHERE material_id = $1 ORDER BY check_date DESC, id DESC",
+		materialID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	checks := []RotorBarCheck{}
+	for rows.Next() {
+		check, err := scanRotorBarCheck(rows)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	return checks, nil
+}
+
+// Function to insert a rotor bar check, the material's rotor_bar status follows through a trigger
+func insertRotorBarCheck(ctx context.Context, db querier, check RotorBarCheck) (RotorBarCheck, error) {
+	query := `INSERT INTO public.rotor_bar_checks
+	(material_id, check_date, status, inspector, reason, findings, attachments)
+	VALUES(@material_id, @check_date::date, @status, @inspector, @reason, @findings, @attachments)
+	RETURNING ` + rotorBarCheckColumns
+	args := pgx.NamedArgs{
+		"material_id": check.MaterialID,
+		"check_date":  check.CheckDate,
+		"status":      check.Status,
+		"inspector":   check.Inspector,
+		"reason":      check.Reason,
+		"findings":    check.Findings,
+		"attachments": check.Attachments,
+	}
+
+	return scanRotorBarCheck(db.QueryRow(ctx, query, args))
+}
+
+// Function to scan one row selected with rotorBarCheckColumns
+func scanRotorBarCheck(row pgx.Row) (RotorBarCheck, error) {
+	var check RotorBarCheck
+	err := row.Scan(
+		&check.ID, &check.MaterialID, &check.CheckDate, &check.Status, &check.Inspector,
+		&check.Reason, &check.Findings, &check.Attachments, &check.CreatedAt,
+	)
+	return check, err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// searchFields are the columns q= can match, in the order a match is attributed to them
+var searchFields = []string{"name", "serial_number", "maker", "remark", "operation", "rotor_bar_remark"}
+
+// SearchMatch tells why a material matched q=, Highlight is the HTML escaped value with the matches in <mark>
+type SearchMatch struct {
+	Field     string  `json:"field"`
+	Value     string  `json:"value"`
+	Highlight string  `json:"highlight"`
+	Rank      float64 `json:"rank"`
+}
+
+// Function to reduce text to its lowercase letters and digits, like public.search_key,
+// so "A-122BC" and "a122bc" compare equal
+func searchKey(text string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
+
+// Function to turn q into a tsquery of word prefixes, every word must match. "" when q has no letter or digit.
+func searchTSQuery(q string) string {
+	var terms []string
+	for _, word := range strings.Fields(q) {
+		// Only letters and digits are left, nothing in them is tsquery syntax
+		if key := searchKey(word); key != "" {
+			terms = append(terms, key+":*")
+		}
+	}
+	return strings.Join(terms, " & ")
+}
+
+// Function to build the condition, rank and matched field SQL of a search.
+// tsquery and key are the placeholders of searchTSQuery(q) and searchKey(q).
+func searchClauses(tsquery, key string) (where, rank, field string) {
+	key += "::text"
+	query := "to_tsquery('simple', " + tsquery + "::text)"
+	contains := func(column string) string {
+		return "public.search_key(" + column + ") LIKE '%' || " + key + " || '%'"
+	}
+	similar := func(column string) string {
+		return key + " % public.search_key(" + column + ")"
+	}
+	similarity := func(column string) string {
+		return "similarity(public.search_key(" + column + "), " + key + ")"
+	}
+
+	// Full-text search covers every field, tags are also matched ignoring punctuation and by trigram similarity
+	where = "(search_document @@ " + query +
+		" OR " + contains("name") + " OR " + contains("serial_number") +
+		" OR " + similar("name") + " OR " + similar("serial_number") + ")"
+
+	// A tag containing q outranks a fuzzy match, which outranks a word in a remark
+	rank = "(ts_rank(search_document, " + query + ")" +
+		" + CASE WHEN " + contains("name") + " OR " + contains("serial_number") + " THEN 1 ELSE 0 END" +
+		" + greatest(" + similarity("name") + ", " + similarity("serial_number") + "))::float8"
+
+	field = "CASE"
+	for _, column := range searchFields {
+		field += " WHEN to_tsvector('simple', " + column + ") @@ " + query + " OR " + contains(column) + " THEN '" + column + "'"
+	}
+	field += " WHEN " + similarity("name") + " >= " + similarity("serial_number") + " THEN 'name' ELSE 'serial_number' END"
+
+	return where, rank, field
+}
+
+// Function to fill in the match of a material found by q, field is the column searchClauses attributed it to
+func newSearchMatch(material Material, q, field string, rank float64) *SearchMatch {
+	values := map[string]string{
+		"name":             material.Name,
+		"serial_number":    material.SerialNumber,
+		"maker":            material.Maker,
+		"remark":           material.Remark,
+		"operation":        material.Operation,
+		"rotor_bar_remark": material.RotorBar.Remark,
+	}
+	value := values[field]
+	return &SearchMatch{Field: field, Value: value, Highlight: highlight(value, q), Rank: rank}
+}
+
+// Function to mark the parts of value matching q, ignoring case and punctuation.
+// A fuzzy match has no matching part, the whole value is marked instead.
+func highlight(value, q string) string {
+	// Letters and digits of value with the byte range each one came from
+	var key []rune
+	var starts, ends []int
+	for i, r := range value {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			for _, lower := range strings.ToLower(string(r)) {
+				if !unicode.IsLetter(lower) && !unicode.IsDigit(lower) {
+					continue
+				}
+				key = append(key, lower)
+				starts = append(starts, i)
+				ends = append(ends, i+len(string(r)))
+			}
+		}
+	}
+
+	// Every word of q is looked for, and q as a whole so "A 122" finds "A-122"
+	terms := append(strings.Fields(q), q)
+	var spans [][2]int
+	for _, term := range terms {
+		term := []rune(searchKey(term))
+		if len(term) == 0 {
+			continue
+		}
+		for i := 0; i+len(term) <= len(key); i++ {
+			if string(key[i:i+len(term)]) == string(term) {
+				spans = append(spans, [2]int{starts[i], ends[i+len(term)-1]})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return "<mark>" + html.EscapeString(value) + "</mark>"
+	}
+
+	// Overlapping spans are merged so marks never nest
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	var marked strings.Builder
+	at := 0
+	for i := 0; i < len(spans); i++ {
+		start, end := spans[i][0], spans[i][1]
+		for i+1 < len(spans) && spans[i+1][0] <= end {
+			i++
+			if spans[i][1] > end {
+				end = spans[i][1]
+			}
+		}
+		marked.WriteString(html.EscapeString(value[at:start]))
+		marked.WriteString("<mark>" + html.EscapeString(value[start:end]) + "</mark>")
+		at = end
+	}
+	marked.WriteString(html.EscapeString(value[at:]))
+	return marked.String()
+}
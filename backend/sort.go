@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// sortColumns maps the fields of Material that ?sort= accepts to their column
+var sortColumns = map[string]string{
+	"id": "id", "qcode": "qcode", "plant": "plant", "area": "area", "category": "category", "name": "name",
+	"capacity": "capacity", "voltage": "voltage", "current": "current", "rpm": "rpm", "frame": "frame",
+	"shaft_diameter": "shaft_diameter", "base_width": "base_width", "base_length": "base_length",
+	"c": "c", "e": "e", "h": "h", "maker": "maker", "serial_number": "serial_number", "type": "type",
+	"installed_qty": "installed_qty", "standby_qty": "standby_qty", "spare_qty": "spare_qty",
+	"rotor_bar_check_date": "rotor_bar_check_date", "created_at": "created_at", "updated_at": "updated_at",
+}
+
+// SortKey is one field of ?sort=, a leading "-" in the parameter sorts it descending
+type SortKey struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc"`
+}
+
+// Function to read a comma separated list of sort keys such as "-capacity,plant,name", nil when missing
+func (p *queryParser) sort(name string, allowed []string) []SortKey {
+	value := strings.TrimSpace(p.query.Get(name))
+	if value == "" {
+		return nil
+	}
+
+	known := map[string]bool{}
+	for _, field := range allowed {
+		known[field] = true
+	}
+
+	var keys []SortKey
+	seen := map[string]bool{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		key := SortKey{Field: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		if !known[key.Field] {
+			p.fail(name, field, "must be one of "+strings.Join(allowed, ", ")+", with a leading - to sort descending")
+			continue
+		}
+		if seen[key.Field] {
+			p.fail(name, field, "is given more than once")
+			continue
+		}
+		seen[key.Field] = true
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Function to list the fields of sortColumns in alphabetical order
+func sortFields() []string {
+	fields := make([]string, 0, len(sortColumns))
+	for field := range sortColumns {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Function to build the ORDER BY of keys. id breaks ties so pages are repeatable,
+// in the direction of the last key so an index on (..., column, id) can be scanned either way.
+func buildOrderBy(keys []SortKey) string {
+	terms := make([]string, 0, len(keys)+1)
+	tiebreaker := "id"
+	for _, key := range keys {
+		term := sortColumns[key.Field]
+		if key.Desc {
+			term += " DESC"
+		}
+		terms = append(terms, term)
+		if key.Field == "id" {
+			// id is unique, nothing after it can change the order
+			return " ORDER BY " + strings.Join(terms, ", ")
+		}
+		tiebreaker = "id"
+		if key.Desc {
+			tiebreaker = "id DESC"
+		}
+	}
+
+	return " ORDER BY " + strings.Join(append(terms, tiebreaker), ", ")
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// defaultMinSpareRatio is the spare to installed ratio below which a family is flagged, when min_ratio is not given
+const defaultMinSpareRatio = 0.1
+
+// Flags of a family or plant in the spare coverage report
+const (
+	FlagNoSpare       = "no_spare"
+	FlagLowSpareRatio = "low_spare_ratio"
+)
+
+// familyColumns are the attributes two motors must share to be interchangeable
+const familyColumns = "voltage, capacity, rpm, frame, shaft_diameter, base_width, base_length, c, e, h"
+
+// MotorFamily is a set of interchangeable motors, identified by their specifications and mounting size
+type MotorFamily struct {
+	Voltage       float64 `json:"voltage"`
+	Capacity      float64 `json:"capacity"`
+	RPM           float64 `json:"rpm"`
+	Frame         int     `json:"frame"`
+	ShaftDiameter float64 `json:"shaft_diameter"`
+	BaseWidth     float64 `json:"base_width"`
+	BaseLength    float64 `json:"base_length"`
+	C             float64 `json:"c"`
+	E             float64 `json:"e"`
+	H             float64 `json:"h"`
+}
+
+// SpareCoverage counts the motors of a family, or of a family in one plant
+type SpareCoverage struct {
+	Motors     int      `json:"motors"`
+	Installed  int      `json:"installed"`
+	StandBy    int      `json:"standby"`
+	Spare      int      `json:"spare"`
+	SpareRatio *float64 `json:"spare_ratio"`
+	Flags      []string `json:"flags"`
+}
+
+type PlantCoverage struct {
+	Plant string `json:"plant"`
+	SpareCoverage
+	MaterialIDs []int `json:"material_ids"`
+}
+
+type FamilyCoverage struct {
+	Family MotorFamily `json:"family"`
+	SpareCoverage
+	Plants []PlantCoverage `json:"plants"`
+}
+
+type SpareCoverageResponse struct {
+	Request struct {
+		MinRatio    float64 `json:"min_ratio"`
+		FlaggedOnly bool    `json:"flagged_only"`
+	} `json:"request"`
+	Response struct {
+		Count int `json:"count"`
+		// Unclassified motors miss voltage, capacity or rpm and cannot be grouped
+		Unclassified int              `json:"unclassified"`
+		Success      bool             `json:"success"`
+		Data         []FamilyCoverage `json:"data"`
+	} `json:"response"`
+}
+
+// Function to report installed, standby and spare counts of every family of interchangeable HV motors
+func getSpareCoverage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := newQueryParser(r)
+	minRatio := defaultMinSpareRatio
+	if r.URL.Query().Get("min_ratio") != "" {
+		minRatio = query.float("min_ratio")
+		if minRatio < 0 {
+			query.fail("min_ratio", r.URL.Query().Get("min_ratio"), "must not be negative")
+		}
+	}
+	flaggedOnly := query.bool("flagged")
+	if !query.valid(w) {
+		return
+	}
+
+	families, unclassified, err := recordRepo.SpareCoverage(r.Context(), hvMotorCategory.Name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting spare coverage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	data := []FamilyCoverage{}
+	for _, family := range families {
+		family.flag(minRatio)
+		for i := range family.Plants {
+			family.Plants[i].flag(minRatio)
+		}
+		if flaggedOnly && len(family.Flags) == 0 {
+			continue
+		}
+		data = append(data, family)
+	}
+
+	// Most critical first: no spare, then the lowest ratio, then the most motors installed
+	sort.SliceStable(data, func(i, j int) bool {
+		a, b := data[i], data[j]
+		if (a.Spare == 0) != (b.Spare == 0) {
+			return a.Spare == 0
+		}
+		if ratioOf(a.SpareCoverage) != ratioOf(b.SpareCoverage) {
+			return ratioOf(a.SpareCoverage) < ratioOf(b.SpareCoverage)
+		}
+		return a.Installed > b.Installed
+	})
+
+	var response SpareCoverageResponse
+	response.Request.MinRatio = minRatio
+	response.Request.FlaggedOnly = flaggedOnly
+	response.Response.Count = len(data)
+	response.Response.Unclassified = unclassified
+	response.Response.Success = true
+	response.Response.Data = data
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Function to compute the spare ratio and flags of a coverage, a family with nothing installed has no ratio
+func (coverage *SpareCoverage) flag(minRatio float64) {
+	coverage.Flags = []string{}
+	if coverage.Installed > 0 {
+		ratio := float64(coverage.Spare) / float64(coverage.Installed)
+		coverage.SpareRatio = &ratio
+	}
+	if coverage.Spare == 0 {
+		coverage.Flags = append(coverage.Flags, FlagNoSpare)
+	}
+	if coverage.SpareRatio != nil && *coverage.SpareRatio < minRatio {
+		coverage.Flags = append(coverage.Flags, FlagLowSpareRatio)
+	}
+}
+
+// ratioOf sorts coverages without a ratio, nothing installed, after every other
+func ratioOf(coverage SpareCoverage) float64 {
+	if coverage.SpareRatio == nil {
+		return float64(coverage.Spare) + 1e9
+	}
+	return *coverage.SpareRatio
+}
+
+// Function to count the motors of category per family and plant in the database.
+// It also returns how many motors could not be grouped.
+func selectSpareCoverage(ctx context.Context, db querier, category string) ([]FamilyCoverage, int, error) {
+	rows, err := db.Query(ctx, `SELECT `+familyColumns+`, plant,
+		count(*), COALESCE(sum(installed_qty), 0), COALESCE(sum(standby_qty), 0), COALESCE(sum(spare_qty), 0),
+		array_agg(id ORDER BY id)
+	FROM (
+		SELECT id, plant, installed_qty, standby_qty, spare_qty,
+			voltage, capacity, rpm, COALESCE(frame, 0) AS frame, COALESCE(shaft_diameter, 0) AS shaft_diameter,
+			COALESCE(base_width, 0) AS base_width, COALESCE(base_length, 0) AS base_length,
+			COALESCE(c, 0) AS c, COALESCE(e, 0) AS e, COALESCE(h, 0) AS h
+		FROM public.list_materials
+		WHERE category = $1 AND voltage > 0 AND capacity > 0 AND rpm > 0
+	) motors
+	GROUP BY `+familyColumns+`, plant
+	ORDER BY `+familyColumns+`, plant`, category)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	families := []FamilyCoverage{}
+	for rows.Next() {
+		var family MotorFamily
+		var plant PlantCoverage
+		err := rows.Scan(
+			&family.Voltage, &family.Capacity, &family.RPM, &family.Frame, &family.ShaftDiameter,
+			&family.BaseWidth, &family.BaseLength, &family.C, &family.E, &family.H, &plant.Plant,
+			&plant.Motors, &plant.Installed, &plant.StandBy, &plant.Spare, &plant.MaterialIDs,
+		)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to scan row: %w", err)
+		}
+
+		// Rows are ordered by family, so a family only has to be compared with the previous one
+		if len(families) == 0 || families[len(families)-1].Family != family {
+			families = append(families, FamilyCoverage{Family: family})
+		}
+		current := &families[len(families)-1]
+		current.Plants = append(current.Plants, plant)
+		current.Motors += plant.Motors
+		current.Installed += plant.Installed
+		current.StandBy += plant.StandBy
+		current.Spare += plant.Spare
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	var unclassified int
+	err = db.QueryRow(ctx, `SELECT count(*) FROM public.list_materials
+		WHERE category = $1 AND NOT (COALESCE(voltage, 0) > 0 AND COALESCE(capacity, 0) > 0 AND COALESCE(rpm, 0) > 0)`,
+		category).Scan(&unclassified)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to count unclassified motors: %w", err)
+	}
+
+	return families, unclassified, nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Starting current check frequencies, an empty frequency means the motor is not scheduled
+const (
+	FrequencyEveryStart = "every_start"
+	FrequencyMonthly    = "monthly"
+	FrequencyOverhaul   = "overhaul"
+)
+
+var startingCurrentFrequencies = []string{FrequencyEveryStart, FrequencyMonthly, FrequencyOverhaul}
+
+// Starting current events of a motor, each moves the matching starting_current_last_* date
+const (
+	EventCheck    = "check"
+	EventStart    = "start"
+	EventOverhaul = "overhaul"
+)
+
+var startingCurrentEvents = []string{EventCheck, EventStart, EventOverhaul}
+
+// startingCurrentEventColumns is the column list every starting current event query selects, in scanStartingCurrentEvent order
+const startingCurrentEventColumns = "id, material_id, event, to_char(event_date, 'YYYY-MM-DD'), measured_current::float8, recorded_by, remark, created_at"
+
+// StartingCurrentEvent is a check, start or overhaul of a motor, EventDate is formatted YYYY-MM-DD.
+// MeasuredCurrent is the starting current in A a check measured, only checks carry one.
+type StartingCurrentEvent struct {
+	ID              int64     `json:"id"`
+	MaterialID      int       `json:"material_id"`
+	Event           string    `json:"event"`
+	EventDate       string    `json:"event_date"`
+	MeasuredCurrent *float64  `json:"measured_current"`
+	RecordedBy      string    `json:"recorded_by"`
+	Remark          string    `json:"remark"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type StartingCurrentEventsResponse struct {
+	Response struct {
+		Count   int                    `json:"count"`
+		Success bool                   `json:"success"`
+		Data    []StartingCurrentEvent `json:"data"`
+	} `json:"response"`
+}
+
+type StartingCurrentEventResponse struct {
+	Response struct {
+		Success bool                 `json:"success"`
+		Data    StartingCurrentEvent `json:"data"`
+		// Material shows the starting current dates and schedule after the event
+		Material Material `json:"material"`
+	} `json:"response"`
+}
+
+// Statuses of a scheduled starting current check in the overdue report
+const (
+	CheckNeverDone = "never_done"
+	CheckOverdue   = "overdue"
+)
+
+// OverdueMotor is a motor whose starting current check is due
+type OverdueMotor struct {
+	ID           int        `json:"id"`
+	Name         string     `json:"name"`
+	Frequency    string     `json:"frequency"`
+	Status       string     `json:"status"`
+	LastCheck    *time.Time `json:"last_check"`
+	DueSince     *time.Time `json:"due_since"`
+	LastStart    *time.Time `json:"last_start"`
+	LastOverhaul *time.Time `json:"last_overhaul"`
+}
+
+type OverdueArea struct {
+	Area   string         `json:"area"`
+	Count  int            `json:"count"`
+	Motors []OverdueMotor `json:"motors"`
+}
+
+type OverduePlant struct {
+	Plant string        `json:"plant"`
+	Count int           `json:"count"`
+	Areas []OverdueArea `json:"areas"`
+}
+
+type OverdueResponse struct {
+	Request struct {
+		AsOf  string `json:"as_of"`
+		Plant string `json:"plant,omitempty"`
+		Area  string `json:"area,omitempty"`
+	} `json:"request"`
+	Response struct {
+		Count   int            `json:"count"`
+		Success bool           `json:"success"`
+		Data    []OverduePlant `json:"data"`
+	} `json:"response"`
+}
+
+// Function to report the HV motors whose starting current check is due, optionally of one ?plant= and ?area=
+func getStartingCurrentOverdue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := newQueryParser(r)
+	asOf := query.date("as_of", "must be a YYYY-MM-DD date", "2006-01-02")
+	if !query.valid(w) {
+		return
+	}
+	if asOf.IsZero() {
+		asOf = time.Now()
+	}
+
+	// Only HV motors are scheduled, the other categories never leave the database
+	params := QueryParams{Category: hvMotorCategory.Name}
+	plant, area := strings.TrimSpace(r.URL.Query().Get("plant")), strings.TrimSpace(r.URL.Query().Get("area"))
+	if plant != "" {
+		params.Texts = append(params.Texts, textColumn{Column: "plant", Name: "plant", Value: plant})
+	}
+	if area != "" {
+		params.Texts = append(params.Texts, textColumn{Column: "area", Name: "area", Value: area})
+	}
+
+	page, err := materialRepo.List(r.Context(), params, 0, 0)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting materials: %v", err), http.StatusInternalServerError)
+		return
+	}
+	materials := page.Materials
+
+	// Ordered by plant and area, so grouping only has to watch for changes
+	sort.SliceStable(materials, func(i, j int) bool {
+		a, b := materials[i], materials[j]
+		if a.Plant != b.Plant {
+			return a.Plant < b.Plant
+		}
+		if a.Area != b.Area {
+			return a.Area < b.Area
+		}
+		return a.Name < b.Name
+	})
+
+	var response OverdueResponse
+	response.Request.AsOf = asOf.Format("2006-01-02")
+	response.Request.Plant, response.Request.Area = plant, area
+	response.Response.Data = []OverduePlant{}
+	for _, material := range materials {
+		status, dueSince := startingCurrentDue(material, asOf)
+		if status == "" {
+			continue
+		}
+
+		plants := response.Response.Data
+		if len(plants) == 0 || plants[len(plants)-1].Plant != material.Plant {
+			plants = append(plants, OverduePlant{Plant: material.Plant})
+		}
+		plant := &plants[len(plants)-1]
+		if len(plant.Areas) == 0 || plant.Areas[len(plant.Areas)-1].Area != material.Area {
+			plant.Areas = append(plant.Areas, OverdueArea{Area: material.Area})
+		}
+		area := &plant.Areas[len(plant.Areas)-1]
+
+		area.Motors = append(area.Motors, OverdueMotor{
+			ID:           material.ID,
+			Name:         material.Name,
+			Frequency:    material.StartingCurrent.Frequency,
+			Status:       status,
+			LastCheck:    material.StartingCurrent.LastCheck,
+			DueSince:     dueSince,
+			LastStart:    material.StartingCurrent.LastStart,
+			LastOverhaul: material.StartingCurrent.LastOverhaul,
+		})
+		area.Count++
+		plant.Count++
+		response.Response.Count++
+		response.Response.Data = plants
+	}
+	response.Response.Success = true
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Function to decide whether the starting current check of a material is due at asOf.
+// It returns "" when the motor is not scheduled or its check is up to date.
+func startingCurrentDue(material Material, asOf time.Time) (status string, dueSince *time.Time) {
+	schedule := material.StartingCurrent
+	if schedule.Frequency == "" {
+		return "", nil
+	}
+	if schedule.LastCheck == nil {
+		return CheckNeverDone, nil
+	}
+
+	switch schedule.Frequency {
+	case FrequencyMonthly:
+		due := schedule.LastCheck.AddDate(0, 1, 0)
+		if due.Before(asOf) {
+			return CheckOverdue, &due
+		}
+	case FrequencyEveryStart:
+		if schedule.LastStart != nil && schedule.LastStart.After(*schedule.LastCheck) {
+			return CheckOverdue, schedule.LastStart
+		}
+	case FrequencyOverhaul:
+		if schedule.LastOverhaul != nil && schedule.LastOverhaul.After(*schedule.LastCheck) {
+			return CheckOverdue, schedule.LastOverhaul
+		}
+	}
+
+	return "", nil
+}
+
+// Function to report whether frequency is empty or a known starting current frequency
+func validFrequency(frequency string) bool {
+	if frequency == "" {
+		return true
+	}
+	for _, known := range startingCurrentFrequencies {
+		if frequency == known {
+			return true
+		}
+	}
+	return false
+}
+
+func getStartingCurrentEvents(w http.ResponseWriter, r *http.Request, id int) {
+	if _, err := materialRepo.Get(r.Context(), id); err != nil {
+		writeMaterialLookupError(w, id, err)
+		return
+	}
+
+	events, err := recordRepo.StartingCurrentEvents(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting starting current events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response StartingCurrentEventsResponse
+	response.Response.Count = len(events)
+	response.Response.Success = true
+	response.Response.Data = events
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func createStartingCurrentEvent(w http.ResponseWriter, r *http.Request, id int) {
+	material, err := materialRepo.Get(r.Context(), id)
+	if err != nil {
+		writeMaterialLookupError(w, id, err)
+		return
+	}
+	if !authorize(w, r, RolePlanner, material.Plant) {
+		return
+	}
+
+	var event StartingCurrentEvent
+	if err := decodeJSON(r, &event); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	event.MaterialID = id
+	if event.RecordedBy == "" {
+		event.RecordedBy = currentUser(r).Username
+	}
+	if err := validateStartingCurrentEvent(&event); err != nil {
+		writeInputError(w, err)
+		return
+	}
+
+	created, updated, err := recordRepo.AddStartingCurrentEvent(r.Context(), event, requestChange(r))
+	// The material can still be deleted between the lookup and the insert
+	if errors.Is(err, ErrMaterialNotFound) {
+		http.Error(w, fmt.Sprintf("Material %d not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error inserting starting current event: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response StartingCurrentEventResponse
+	response.Response.Success = true
+	response.Response.Data = created
+	response.Response.Material = updated
+
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// Function to normalise a decoded starting current event and validate it
+func validateStartingCurrentEvent(event *StartingCurrentEvent) error {
+	var problems InputErrors
+
+	event.Event = strings.ToLower(strings.TrimSpace(event.Event))
+	event.RecordedBy = strings.TrimSpace(event.RecordedBy)
+
+	known := false
+	for _, name := range startingCurrentEvents {
+		known = known || event.Event == name
+	}
+	if !known {
+		problems = append(problems, InputError{Field: "event", Value: event.Event, Reason: "must be one of " + strings.Join(startingCurrentEvents, ", ")})
+	}
+	if _, err := time.Parse("2006-01-02", event.EventDate); err != nil {
+		problems = append(problems, InputError{Field: "event_date", Value: event.EventDate, Reason: "must be a YYYY-MM-DD date"})
+	}
+	if event.MeasuredCurrent != nil {
+		value := strconv.FormatFloat(*event.MeasuredCurrent, 'f', -1, 64)
+		if event.Event != EventCheck {
+			problems = append(problems, InputError{Field: "measured_current", Value: value, Reason: "is only recorded by a check"})
+		} else if *event.MeasuredCurrent < 0 {
+			problems = append(problems, InputError{Field: "measured_current", Value: value, Reason: "must not be negative"})
+		}
+	}
+	if event.RecordedBy == "" {
+		problems = append(problems, InputError{Field: "recorded_by", Reason: "is required"})
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
+
+// Function to select the starting current events of a material, latest first
+func selectStartingCurrentEvents(ctx context.Context, db querier, materialID int) ([]StartingCurrentEvent, error) {
+	rows, err := db.Query(ctx,
+		"SELECT "+startingCurrentEventColumns+" FROM public.starting_current_events WHERE material_id = $1 ORDER BY event_date DESC, id DESC",
+		materialID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	events := []StartingCurrentEvent{}
+	for rows.Next() {
+		event, err := scanStartingCurrentEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	return events, nil
+}
+
+// Function to insert a starting current event, the material's starting_current_last_* dates follow through a trigger
+func insertStartingCurrentEvent(ctx context.Context, db querier, event StartingCurrentEvent) (StartingCurrentEvent, error) {
+	query := `INSERT INTO public.starting_current_events
+	(material_id, event, event_date, measured_current, recorded_by, remark)
+	VALUES(@material_id, @event, @event_date::date, @measured_current, @recorded_by, @remark)
+	RETURNING ` + startingCurrentEventColumns
+	args := pgx.NamedArgs{
+		"material_id":      event.MaterialID,
+		"event":            event.Event,
+		"event_date":       event.EventDate,
+		"measured_current": event.MeasuredCurrent,
+		"recorded_by":      event.RecordedBy,
+		"remark":           event.Remark,
+	}
+
+	return scanStartingCurrentEvent(db.QueryRow(ctx, query, args))
+}
+
+// Function to scan one row selected with startingCurrentEventColumns
+func scanStartingCurrentEvent(row pgx.Row) (StartingCurrentEvent, error) {
+	var event StartingCurrentEvent
+	err := row.Scan(
+		&event.ID, &event.MaterialID, &event.Event, &event.EventDate, &event.MeasuredCurrent,
+		&event.RecordedBy, &event.Remark, &event.CreatedAt,
+	)
+	return event, err
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// checkViolation is the Postgres error code raised when a movement would make a balance negative or exceed its bound
+const checkViolation = "23514"
+
+// StockRejectedError is returned for a movement that would take more out of a location than it holds
+type StockRejectedError struct {
+	Reason string
+}
+
+func (err StockRejectedError) Error() string {
+	return "stock movement rejected: " + err.Reason
+}
+
+// maxStockQuantity bounds a single movement and an imported quantity, like the stock_movements_quantity_max constraint
+const maxStockQuantity = 10000
+
+// Locations a motor can be moved between. The first five hold a balance that can never go negative,
+// the last three are the outside world and only ever send or receive.
+const (
+	LocationInstalled = "installed"
+	LocationStandBy   = "standby"
+	LocationSpare     = "spare"
+	LocationWorkshop  = "workshop"
+	LocationRewind    = "rewind"
+	LocationSupplier  = "supplier"
+	LocationScrap     = "scrap"
+	LocationStocktake = "stocktake"
+)
+
+// balanceLocations are the locations with a balance, installed, standby and spare are also stored on the material
+var balanceLocations = []string{LocationInstalled, LocationStandBy, LocationSpare, LocationWorkshop, LocationRewind}
+
+// Kinds of stock movement
+const (
+	MovementInstall          = "install"
+	MovementRemoveToWorkshop = "remove_to_workshop"
+	MovementSendToRewind     = "send_to_rewind"
+	MovementReceiveSpare     = "receive_spare"
+	MovementScrap            = "scrap"
+	MovementTransfer         = "transfer"
+	MovementStocktake        = "stocktake"
+)
+
+// movementRoute lists the locations a kind of movement may come from and go to, a side with one location is its default
+type movementRoute struct {
+	From []string
+	To   []string
+}
+
+var movementRoutes = map[string]movementRoute{
+	MovementInstall: {
+		From: []string{LocationSpare, LocationStandBy, LocationWorkshop, LocationRewind},
+		To:   []string{LocationInstalled},
+	},
+	MovementRemoveToWorkshop: {
+		From: []string{LocationInstalled, LocationStandBy, LocationSpare},
+		To:   []string{LocationWorkshop},
+	},
+	MovementSendToRewind: {
+		From: []string{LocationWorkshop, LocationInstalled, LocationStandBy, LocationSpare},
+		To:   []string{LocationRewind},
+	},
+	MovementReceiveSpare: {
+		From: []string{LocationSupplier, LocationWorkshop, LocationRewind},
+		To:   []string{LocationSpare, LocationStandBy},
+	},
+	MovementScrap: {
+		From: []string{LocationWorkshop, LocationRewind, LocationInstalled, LocationStandBy, LocationSpare},
+		To:   []string{LocationScrap},
+	},
+	MovementTransfer: {
+		From: []string{LocationInstalled, LocationStandBy, LocationSpare},
+		To:   []string{LocationInstalled, LocationStandBy, LocationSpare},
+	},
+}
+
+// stockMovementColumns is the column list every movement query selects, in scanStockMovement order
+const stockMovementColumns = "id, material_id, movement, from_location, to_location, quantity, reason, reference, actor, moved_at"
+
+// StockMovement is one entry of the stock ledger of a material
+type StockMovement struct {
+	ID           int64     `json:"id"`
+	MaterialID   int       `json:"material_id"`
+	Movement     string    `json:"movement"`
+	FromLocation string    `json:"from"`
+	ToLocation   string    `json:"to"`
+	Quantity     int       `json:"quantity"`
+	Reason       string    `json:"reason"`
+	Reference    string    `json:"reference"`
+	Actor        string    `json:"actor"`
+	MovedAt      time.Time `json:"moved_at"`
+}
+
+type StockMovementsResponse struct {
+	Response struct {
+		Count    int             `json:"count"`
+		Success  bool            `json:"success"`
+		Balances map[string]int  `json:"balances"`
+		Data     []StockMovement `json:"data"`
+	} `json:"response"`
+}
+
+type StockMovementResponse struct {
+	Response struct {
+		Success  bool           `json:"success"`
+		Balances map[string]int `json:"balances"`
+		Data     StockMovement  `json:"data"`
+	} `json:"response"`
+}
+
+func getStockMovements(w http.ResponseWriter, r *http.Request, id int) {
+	if _, err := materialRepo.Get(r.Context(), id); err != nil {
+		writeMaterialLookupError(w, id, err)
+		return
+	}
+
+	movements, err := recordRepo.StockMovements(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting stock movements: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response StockMovementsResponse
+	response.Response.Count = len(movements)
+	response.Response.Success = true
+	response.Response.Balances = stockBalances(movements)
+	response.Response.Data = movements
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func createStockMovement(w http.ResponseWriter, r *http.Request, id int) {
+	material, err := materialRepo.Get(r.Context(), id)
+	if err != nil {
+		writeMaterialLookupError(w, id, err)
+		return
+	}
+
+	var movement StockMovement
+	if err := decodeJSON(r, &movement); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	movement.MaterialID = id
+	movement.Actor = currentUser(r).Username
+	if err := validateStockMovement(&movement); err != nil {
+		writeInputError(w, err)
+		return
+	}
+
+	// A stocktake corrects the books rather than moving a motor, so it is kept to maintenance admins
+	role := RolePlanner
+	if movement.Movement == MovementStocktake {
+		role = RoleMaintenanceAdmin
+	}
+	if !authorize(w, r, role, material.Plant) {
+		return
+	}
+
+	created, err := recordRepo.AddStockMovement(r.Context(), movement, requestChange(r))
+	var rejected StockRejectedError
+	if errors.As(err, &rejected) {
+		http.Error(w, fmt.Sprintf("Stock movement rejected: %s", rejected.Reason), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, ErrMaterialNotFound) {
+		http.Error(w, fmt.Sprintf("Material %d not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error inserting stock movement: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	movements, err := recordRepo.StockMovements(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting stock movements: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response StockMovementResponse
+	response.Response.Success = true
+	response.Response.Balances = stockBalances(movements)
+	response.Response.Data = created
+
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// Function to normalise a decoded movement, fill in its default route and validate it.
+// Balances are checked by the database when the movement is inserted.
+func validateStockMovement(movement *StockMovement) error {
+	var problems InputErrors
+
+	movement.Movement = strings.ToLower(strings.TrimSpace(movement.Movement))
+	movement.FromLocation = strings.ToLower(strings.TrimSpace(movement.FromLocation))
+	movement.ToLocation = strings.ToLower(strings.TrimSpace(movement.ToLocation))
+	movement.Reference = strings.TrimSpace(movement.Reference)
+
+	route, ok := movementRoutes[movement.Movement]
+	if movement.Movement == MovementStocktake {
+		route, ok = movementRoute{From: append([]string{LocationStocktake}, balanceLocations...), To: append([]string{LocationStocktake}, balanceLocations...)}, true
+	}
+	if !ok {
+		kinds := []string{MovementInstall, MovementRemoveToWorkshop, MovementSendToRewind, MovementReceiveSpare, MovementScrap, MovementTransfer, MovementStocktake}
+		problems = append(problems, InputError{Field: "movement", Value: movement.Movement, Reason: "must be one of " + strings.Join(kinds, ", ")})
+	} else {
+		if movement.FromLocation == "" && len(route.From) == 1 {
+			movement.FromLocation = route.From[0]
+		}
+		if movement.ToLocation == "" && len(route.To) == 1 {
+			movement.ToLocation = route.To[0]
+		}
+		if !containsString(route.From, movement.FromLocation) {
+			problems = append(problems, InputError{Field: "from", Value: movement.FromLocation, Reason: fmt.Sprintf("must be one of %s for %s", strings.Join(route.From, ", "), movement.Movement)})
+		}
+		if !containsString(route.To, movement.ToLocation) {
+			problems = append(problems, InputError{Field: "to", Value: movement.ToLocation, Reason: fmt.Sprintf("must be one of %s for %s", strings.Join(route.To, ", "), movement.Movement)})
+		}
+		if movement.FromLocation == movement.ToLocation {
+			problems = append(problems, InputError{Field: "to", Value: movement.ToLocation, Reason: "must differ from from"})
+		}
+		if movement.Movement == MovementStocktake && movement.FromLocation != LocationStocktake && movement.ToLocation != LocationStocktake {
+			problems = append(problems, InputError{Field: "from", Value: movement.FromLocation, Reason: "a stocktake must come from or go to stocktake"})
+		}
+	}
+	if movement.Quantity <= 0 || movement.Quantity > maxStockQuantity {
+		problems = append(problems, InputError{Field: "quantity", Value: strconv.Itoa(movement.Quantity), Reason: fmt.Sprintf("must be from 1 to %d", maxStockQuantity)})
+	}
+	if strings.TrimSpace(movement.Reason) == "" {
+		problems = append(problems, InputError{Field: "reason", Reason: "is required"})
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
+
+// Function to compute the balance of every location from a material's movements
+func stockBalances(movements []StockMovement) map[string]int {
+	balances := map[string]int{}
+	for _, location := range balanceLocations {
+		balances[location] = 0
+	}
+	for _, movement := range movements {
+		if _, ok := balances[movement.FromLocation]; ok {
+			balances[movement.FromLocation] -= movement.Quantity
+		}
+		if _, ok := balances[movement.ToLocation]; ok {
+			balances[movement.ToLocation] += movement.Quantity
+		}
+	}
+	return balances
+}
+
+// Function to select the movements of a material, latest first
+func selectStockMovements(ctx context.Context, db querier, materialID int) ([]StockMovement, error) {
+	rows, err := db.Query(ctx,
+		"SELECT "+stockMovementColumns+" FROM public.stock_movements WHERE material_id = $1 ORDER BY id DESC",
+		materialID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	movements := []StockMovement{}
+	for rows.Next() {
+		movement, err := scanStockMovement(rows)
+		if err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	return movements, nil
+}
+
+// Function to insert a movement, the material's quantities follow through a trigger
+func insertStockMovement(ctx context.Context, db querier, movement StockMovement) (StockMovement, error) {
+	query := `INSERT INTO public.stock_movements
+	(material_id, movement, from_location, to_location, quantity, reason, reference, actor)
+	VALUES(@material_id, @movement, @from_location, @to_location, @quantity, @reason, @reference, @actor)
+	RETURNING ` + stockMovementColumns
+	args := pgx.NamedArgs{
+		"material_id":   movement.MaterialID,
+		"movement":      movement.Movement,
+		"from_location": movement.FromLocation,
+		"to_location":   movement.ToLocation,
+		"quantity":      movement.Quantity,
+		"reason":        movement.Reason,
+		"reference":     movement.Reference,
+		"actor":         movement.Actor,
+	}
+
+	return scanStockMovement(db.QueryRow(ctx, query, args))
+}
+
+// Function to record the opening quantities of a new material as stocktake movements
+func insertOpeningBalances(ctx context.Context, db querier, material Material, actor string) error {
+	return insertStockAdjustments(ctx, db, Material{ID: material.ID}, material, actor, "Opening balance")
+}
+
+// Function to bring the installed, standby and spare balances of current to those of wanted with stocktake movements
+func insertStockAdjustments(ctx context.Context, db querier, current, wanted Material, actor, reason string) error {
+	for _, movement := range stockAdjustments(current, wanted, actor, reason) {
+		if _, err := insertStockMovement(ctx, db, movement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to list the stocktake movements that bring the balances of current to those of wanted
+func stockAdjustments(current, wanted Material, actor, reason string) []StockMovement {
+	var movements []StockMovement
+	quantities := []struct {
+		Location string
+		Current  int
+		Wanted   int
+	}{
+		{LocationInstalled, current.Installed, wanted.Installed},
+		{LocationStandBy, current.StandBy, wanted.StandBy},
+		{LocationSpare, current.Spare, wanted.Spare},
+	}
+	for _, quantity := range quantities {
+		movement := StockMovement{
+			MaterialID:   current.ID,
+			Movement:     MovementStocktake,
+			FromLocation: LocationStocktake,
+			ToLocation:   quantity.Location,
+			Quantity:     quantity.Wanted - quantity.Current,
+			Reason:       reason,
+			Actor:        actor,
+		}
+		if movement.Quantity == 0 {
+			continue
+		}
+		if movement.Quantity < 0 {
+			movement.FromLocation, movement.ToLocation, movement.Quantity = quantity.Location, LocationStocktake, -movement.Quantity
+		}
+		movements = append(movements, movement)
+	}
+	return movements
+}
+
+// Function to scan one row selected with stockMovementColumns
+func scanStockMovement(row pgx.Row) (StockMovement, error) {
+	var movement StockMovement
+	err := row.Scan(
+		&movement.ID, &movement.MaterialID, &movement.Movement, &movement.FromLocation, &movement.ToLocation,
+		&movement.Quantity, &movement.Reason, &movement.Reference, &movement.Actor, &movement.MovedAt,
+	)
+	return movement, err
+}
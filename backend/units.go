@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// unitFactors maps each unit values are stored in to the units accepted on input, with the factor
+// converting them to the stored unit. Input units are matched regardless of case.
+var unitFactors = map[string]map[string]float64{
+	"kW":  {"w": 0.001, "kw": 1, "mw": 1000, "hp": 0.745699872},
+	"V":   {"v": 1, "kv": 1000},
+	"A":   {"a": 1, "ka": 1000},
+	"rpm": {"rpm": 1},
+	"mm":  {"mm": 1, "cm": 10, "m": 1000, "in": 25.4},
+	"kVA": {"va": 0.001, "kva": 1, "mva": 1000},
+	"kA":  {"a": 0.001, "ka": 1},
+	"L":   {"l": 1},
+	"%":   {"%": 1},
+}
+
+// Function to parse a number in unit, or in another unit of the same quantity such as 750HP or 6.6kV for kW and V.
+// A number without a unit is taken to be in unit already.
+func parseQuantity(value, unit string) (float64, error) {
+	value = strings.TrimSpace(value)
+	// The unit starts at the first letter, except the e of an exponent like 1e3 or 2.5E-2
+	split := len(value)
+	for i, r := range value {
+		if (r == 'e' || r == 'E') && i > 0 && isExponent(value[i+1:]) {
+			continue
+		}
+		if unicode.IsLetter(r) || r == '%' {
+			split = i
+			break
+		}
+	}
+	number, suffix := strings.TrimSpace(value[:split]), strings.ToLower(strings.TrimSpace(value[split:]))
+
+	parsed, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+		return 0, fmt.Errorf("must be a number%s", unitHint(unit))
+	}
+	if suffix == "" {
+		return parsed, nil
+	}
+
+	factor, ok := unitFactors[unit][suffix]
+	if !ok {
+		return 0, fmt.Errorf("must be a number%s", unitHint(unit))
+	}
+	// Rounded to 6 decimals so 3.5in reads as 88.9 mm rather than 88.89999999999999
+	return math.Round(parsed*factor*1e6) / 1e6, nil
+}
+
+// Function to tell whether rest, what follows an e, is the digits of an exponent with an optional sign
+func isExponent(rest string) bool {
+	rest = strings.TrimLeft(rest, "+-")
+	return rest != "" && rest[0] >= '0' && rest[0] <= '9'
+}
+
+// Function to describe the units parseQuantity accepts for unit
+func unitHint(unit string) string {
+	if len(unitFactors[unit]) == 0 {
+		return " without a unit"
+	}
+	var accepted []string
+	for suffix := range unitFactors[unit] {
+		accepted = append(accepted, suffix)
+	}
+	sort.Strings(accepted)
+	return ", optionally followed by one of " + strings.Join(accepted, ", ")
+}
+
+// Function to map each attribute of a category with a unit to that unit
+func (category Category) units() map[string]string {
+	units := map[string]string{}
+	for _, attribute := range category.Attributes {
+		if attribute.Unit != "" {
+			units[attribute.Name] = attribute.Unit
+		}
+	}
+	return units
+}
+
+// Function to return the unit of an attribute of the category, "" when it has none
+func (category Category) unit(name string) string {
+	return category.units()[name]
+}
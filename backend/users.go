@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// minPasswordLength is the shortest password a user can be given
+const minPasswordLength = 10
+
+// Errors every UserRepository returns, whatever stores the users
+var (
+	errUserNotFound = errors.New("user not found")
+	errUserExists   = errors.New("user already exists")
+)
+
+// UserRepository stores users and their roles, requireAuth, login and the user handlers reach them only through userRepo
+type UserRepository interface {
+	// Get returns errUserNotFound when id does not exist
+	Get(ctx context.Context, id int64) (User, error)
+	// GetByUsername also returns the password hash, errUserNotFound when no user has the lowercase username
+	GetByUsername(ctx context.Context, username string) (User, string, error)
+	// List returns every user ordered by username
+	List(ctx context.Context) ([]User, error)
+	// Create stores a user validated by prepareNewUser and its roles, errUserExists when the username is taken
+	Create(ctx context.Context, user User, passwordHash string) (User, error)
+	// SetRoles replaces every role of a user, errUserNotFound when id does not exist
+	SetRoles(ctx context.Context, id int64, plantRoles []PlantRole) (User, error)
+}
+
+var roles = []string{RoleViewer, RolePlanner, RoleMaintenanceAdmin}
+
+// NewUser is the body of POST /auth/users
+type NewUser struct {
+	Username    string      `json:"username"`
+	Password    string      `json:"password"`
+	DisplayName string      `json:"display_name"`
+	Roles       []PlantRole `json:"roles"`
+}
+
+type UsersResponse struct {
+	Response struct {
+		Count   int    `json:"count"`
+		Success bool   `json:"success"`
+		Data    []User `json:"data"`
+	} `json:"response"`
+}
+
+// Function to dispatch /auth/users and /auth/users/{id}/roles, only maintenance admins of every plant get through
+func userRoutes(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, RoleMaintenanceAdmin, allPlants) {
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, authPath+"/users"), "/")
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			getUsers(w, r)
+		case http.MethodPost:
+			createUser(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	idPart, sub, _ := strings.Cut(rest, "/")
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil || sub != "roles" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	replaceUserRoles(w, r, id)
+}
+
+func getUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := userRepo.List(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error selecting users: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response UsersResponse
+	response.Response.Count = len(users)
+	response.Response.Success = true
+	response.Response.Data = users
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func createUser(w http.ResponseWriter, r *http.Request) {
+	var request NewUser
+	if err := decodeJSON(r, &request); err != nil {
+		writeInputError(w, err)
+		return
+	}
+
+	hash, err := prepareNewUser(&request)
+	var invalid InputErrors
+	if errors.As(err, &invalid) {
+		writeInputError(w, invalid)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error hashing password: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := userRepo.Create(r.Context(), User{Username: request.Username, DisplayName: request.DisplayName, Roles: request.Roles}, hash)
+	if errors.Is(err, errUserExists) {
+		http.Error(w, fmt.Sprintf("User %s already exists", request.Username), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error inserting user: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response UserResponse
+	response.Response.Success = true
+	response.Response.Data = user
+
+	writeJSON(w, http.StatusCreated, response)
+}
+
+func replaceUserRoles(w http.ResponseWriter, r *http.Request, id int64) {
+	var plantRoles []PlantRole
+	if err := decodeJSON(r, &plantRoles); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	if problems := validateRoles(plantRoles); len(problems) > 0 {
+		writeInputError(w, problems)
+		return
+	}
+
+	user, err := userRepo.SetRoles(r.Context(), id, plantRoles)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, fmt.Sprintf("User %d not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error updating roles: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var response UserResponse
+	response.Response.Success = true
+	response.Response.Data = user
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Function to validate a list of plant roles, plants are normalised to upper case
+func validateRoles(plantRoles []PlantRole) InputErrors {
+	var problems InputErrors
+	for i := range plantRoles {
+		plantRoles[i].Plant = strings.ToUpper(strings.TrimSpace(plantRoles[i].Plant))
+		if plantRoles[i].Plant == "" {
+			problems = append(problems, InputError{Field: fmt.Sprintf("roles[%d].plant", i), Reason: "is required, use " + allPlants + " for every plant"})
+		}
+		if !containsString(roles, plantRoles[i].Role) {
+			problems = append(problems, InputError{Field: fmt.Sprintf("roles[%d].role", i), Value: plantRoles[i].Role, Reason: "must be one of " + strings.Join(roles, ", ")})
+		}
+	}
+	return problems
+}
+
+// Function to validate and normalise a new user and hash its password, the problems are returned as InputErrors
+func prepareNewUser(request *NewUser) (string, error) {
+	request.Username = strings.ToLower(strings.TrimSpace(request.Username))
+	problems := validateRoles(request.Roles)
+	if request.Username == "" {
+		problems = append(problems, InputError{Field: "username", Reason: "is required"})
+	}
+	if len(request.Password) < minPasswordLength {
+		problems = append(problems, InputError{Field: "password", Reason: fmt.Sprintf("must be at least %d characters", minPasswordLength)})
+	}
+	if len(problems) > 0 {
+		return "", problems
+	}
+
+	return hashPassword(request.Password)
+}
+
+// pgUserRepository stores users in the users table and their roles in user_roles
+type pgUserRepository struct {
+	db *pgxpool.Pool
+}
+
+func newPgUserRepository(db *pgxpool.Pool) *pgUserRepository {
+	return &pgUserRepository{db: db}
+}
+
+func (repo *pgUserRepository) Get(ctx context.Context, id int64) (User, error) {
+	return selectUserByID(ctx, repo.db, id)
+}
+
+func (repo *pgUserRepository) GetByUsername(ctx context.Context, username string) (User, string, error) {
+	return selectUserByUsername(ctx, repo.db, username)
+}
+
+func (repo *pgUserRepository) List(ctx context.Context) ([]User, error) {
+	return selectUsers(ctx, repo.db)
+}
+
+func (repo *pgUserRepository) Create(ctx context.Context, user User, passwordHash string) (User, error) {
+	created, err := insertUser(ctx, repo.db, user, passwordHash)
+	if isUniqueViolation(err) {
+		return User{}, errUserExists
+	}
+	return created, err
+}
+
+func (repo *pgUserRepository) SetRoles(ctx context.Context, id int64, plantRoles []PlantRole) (User, error) {
+	return updateUserRoles(ctx, repo.db, id, plantRoles)
+}
+
+// Function to insert a user and its roles
+func insertUser(ctx context.Context, db *pgxpool.Pool, user User, passwordHash string) (User, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return User{}, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx,
+		"INSERT INTO public.users (username, password_hash, display_name) VALUES(@username, @password_hash, @display_name) RETURNING id",
+		pgx.NamedArgs{"username": user.Username, "password_hash": passwordHash, "display_name": user.DisplayName},
+	).Scan(&id)
+	if err != nil {
+		return User{}, err
+	}
+	if err := insertUserRoles(ctx, tx, id, user.Roles); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return User{}, fmt.Errorf("unable to commit: %w", err)
+	}
+
+	return selectUserByID(ctx, db, id)
+}
+
+// Function to replace every role of a user
+func updateUserRoles(ctx context.Context, db *pgxpool.Pool, id int64, plantRoles []PlantRole) (User, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return User{}, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM public.users WHERE id = $1)", id).Scan(&exists); err != nil {
+		return User{}, err
+	}
+	if !exists {
+		return User{}, errUserNotFound
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM public.user_roles WHERE user_id = $1", id); err != nil {
+		return User{}, err
+	}
+	if err := insertUserRoles(ctx, tx, id, plantRoles); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return User{}, fmt.Errorf("unable to commit: %w", err)
+	}
+
+	return selectUserByID(ctx, db, id)
+}
+
+// Function to insert roles of a user, a later role on the same plant replaces an earlier one
+func insertUserRoles(ctx context.Context, tx pgx.Tx, userID int64, plantRoles []PlantRole) error {
+	for _, plantRole := range plantRoles {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO public.user_roles (user_id, plant, role) VALUES(@user_id, @plant, @role)
+			ON CONFLICT (user_id, plant) DO UPDATE SET role = EXCLUDED.role`,
+			pgx.NamedArgs{"user_id": userID, "plant": plantRole.Plant, "role": plantRole.Role})
+		if err != nil {
+			return fmt.Errorf("unable to insert role: %w", err)
+		}
+	}
+	return nil
+}
+
+// Function to select every user with its roles, ordered by username
+func selectUsers(ctx context.Context, db querier) ([]User, error) {
+	rows, err := db.Query(ctx, "SELECT id FROM public.users ORDER BY username")
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	users := []User{}
+	for _, id := range ids {
+		user, err := selectUserByID(ctx, db, id)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// Function to select a user and its roles by ID
+func selectUserByID(ctx context.Context, db querier, id int64) (User, error) {
+	user, _, err := selectUser(ctx, db, "id = $1", id)
+	return user, err
+}
+
+// Function to select a user, its roles and its password hash by username
+func selectUserByUsername(ctx context.Context, db querier, username string) (User, string, error) {
+	return selectUser(ctx, db, "username = $1", strings.ToLower(strings.TrimSpace(username)))
+}
+
+func selectUser(ctx context.Context, db querier, condition string, arg interface{}) (User, string, error) {
+	var user User
+	var hash string
+	err := db.QueryRow(ctx,
+		"SELECT id, username, display_name, disabled, password_hash FROM public.users WHERE "+condition, arg,
+	).Scan(&user.ID, &user.Username, &user.DisplayName, &user.Disabled, &hash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, "", errUserNotFound
+	}
+	if err != nil {
+		return User{}, "", err
+	}
+
+	rows, err := db.Query(ctx, "SELECT plant, role FROM public.user_roles WHERE user_id = $1 ORDER BY plant", user.ID)
+	if err != nil {
+		return User{}, "", fmt.Errorf("unable to execute query: %w", err)
+	}
+	user.Roles, err = pgx.CollectRows(rows, pgx.RowToStructByPos[PlantRole])
+	if err != nil {
+		return User{}, "", fmt.Errorf("error reading rows: %w", err)
+	}
+
+	return user, hash, nil
+}
+
+// Function to run the create-user command, used to bootstrap the first maintenance admin.
+// The password is read from AUTH_PASSWORD, or from the first line of stdin.
+func runCreateUser(ctx context.Context, users UserRepository, args []string) error {
+	flags := flag.NewFlagSet("create-user", flag.ContinueOnError)
+	username := flags.String("username", "", "login name of the new user")
+	displayName := flags.String("name", "", "display name of the new user")
+	role := flags.String("role", RoleViewer, "role of the user: "+strings.Join(roles, ", "))
+	plant := flags.String("plant", allPlants, "plant the role applies to, "+allPlants+" for every plant")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	password := os.Getenv("AUTH_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("unable to read password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	request := NewUser{
+		Username:    *username,
+		Password:    password,
+		DisplayName: *displayName,
+		Roles:       []PlantRole{{Plant: *plant, Role: *role}},
+	}
+	hash, err := prepareNewUser(&request)
+	if err != nil {
+		return err
+	}
+	user, err := users.Create(ctx, User{Username: request.Username, DisplayName: request.DisplayName, Roles: request.Roles}, hash)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created user %s (%d)\n", user.Username, user.ID)
+	return nil
+}